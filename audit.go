@@ -0,0 +1,57 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditLog is the writer set with SetAuditLog. It is guarded by mu.
+var auditLog io.Writer
+
+// SetAuditLog makes freeport append a line to w for every take and return,
+// with the time, the operation, the ports and their label, if any, e.g.
+//
+//	2024-05-01T12:00:00.123456789Z take 30001,30002 label="TestFoo"
+//
+// Each line is written with a single Write call, after which w is flushed if
+// it has a Flush method, so w can be an *os.File opened for appending or a
+// *bufio.Writer. Lines are written in the order the operations happened,
+// without any internal locks held. A failed write is logged but does not fail
+// the take or return. Passing nil stops the audit log.
+func SetAuditLog(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	auditLog = w
+	updateHooksSetLocked()
+}
+
+// writeAudit writes the audit log line for ev to w.
+func writeAudit(w io.Writer, ev hookEvent) {
+	op := "return"
+	if ev.take {
+		op = "take"
+	}
+	ports := make([]string, len(ev.ports))
+	for i, port := range ev.ports {
+		ports[i] = strconv.Itoa(port)
+	}
+	line := ev.at.UTC().Format(time.RFC3339Nano) + " " + op + " " + strings.Join(ports, ",")
+	if ev.label != "" {
+		line += " label=" + strconv.Quote(ev.label)
+	}
+
+	if _, err := io.WriteString(w, line+"\n"); err != nil {
+		logf("WARN", "failed to write audit log: %v", err)
+		return
+	}
+	if f, ok := w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			logf("WARN", "failed to flush audit log: %v", err)
+		}
+	}
+}
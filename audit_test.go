@@ -0,0 +1,46 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestSetAuditLog(t *testing.T) {
+	defer reset()
+	defer SetAuditLog(nil)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	var buf bytes.Buffer
+	SetAuditLog(&buf)
+
+	ports, err := TakeLabeled("TestSetAuditLog", 2)
+	require.NoError(t, err)
+	Return(ports)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.Regexp(t, regexp.MustCompile(`^\d{4}-\d\d-\d\dT\S+Z take 30001,30002 label="TestSetAuditLog"$`), lines[0])
+	assert.Regexp(t, regexp.MustCompile(`^\S+ return 30001,30002 label="TestSetAuditLog"$`), lines[1])
+
+	// A failing audit log doesn't fail the take.
+	SetAuditLog(failingWriter{})
+	ports, err = Take(1)
+	require.NoError(t, err)
+	Return(ports)
+
+	SetAuditLog(nil)
+	assert.False(t, hooksSet.Load())
+}
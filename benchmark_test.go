@@ -0,0 +1,106 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "testing"
+
+// recyclePending moves every pending port straight back to the free list.
+// Benchmarks use it instead of the background goroutine so that they measure
+// Take and Return rather than the 250ms verification tick.
+func recyclePending() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for pendingPorts.Len() > 0 {
+		freePorts.PushBack(pendingPorts.Remove(pendingPorts.Front()))
+	}
+	wakeSatisfiableLocked()
+}
+
+func benchmarkTakeReturn(b *testing.B, n int) {
+	defer reset()
+
+	PauseVerification()
+	defer ResumeVerification()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ports, err := Take(n)
+		if err != nil {
+			b.Fatalf("err: %v", err)
+		}
+		Return(ports)
+		recyclePending()
+	}
+}
+
+func BenchmarkTakeReturn(b *testing.B) {
+	b.Run("single", func(b *testing.B) { benchmarkTakeReturn(b, 1) })
+	b.Run("ten", func(b *testing.B) { benchmarkTakeReturn(b, 10) })
+
+	b.Run("contiguous", func(b *testing.B) {
+		defer reset()
+
+		PauseVerification()
+		defer ResumeVerification()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			r, err := TakeContiguous(10)
+			if err != nil {
+				b.Fatalf("err: %v", err)
+			}
+			ReturnRange(r)
+			recyclePending()
+		}
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		defer reset()
+
+		PauseVerification()
+		defer ResumeVerification()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				ports, err := Take(1)
+				if err != nil {
+					b.Errorf("err: %v", err)
+					return
+				}
+				Return(ports)
+				recyclePending()
+			}
+		})
+	})
+
+	// With a tiny block most takes are blocked waiting for returned ports,
+	// which exercises the wakeup path.
+	b.Run("blocked", func(b *testing.B) {
+		defer reset()
+		b.Setenv("CL_RESERVE_PORTS", "8")
+
+		PauseVerification()
+		defer ResumeVerification()
+
+		b.ReportAllocs()
+		b.SetParallelism(8)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				ports, err := Take(1)
+				if err != nil {
+					b.Errorf("err: %v", err)
+					return
+				}
+				Return(ports)
+				recyclePending()
+			}
+		})
+	})
+}
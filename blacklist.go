@@ -0,0 +1,37 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "sort"
+
+// blacklistIfChronicLocked moves a stolen port from the lost ports to the
+// blacklist once it has been stolen theftBlacklistThreshold times. Blacklisted
+// ports are never reclaimed. It must be called with mu held.
+func blacklistIfChronicLocked(port int) {
+	if theftBlacklistThreshold <= 0 || theftCounts[port] < theftBlacklistThreshold {
+		return
+	}
+
+	delete(lostPorts, port)
+	blacklist[port] = struct{}{}
+	logf("WARN", "port %v was stolen %d times; blacklisting it permanently", logPort(port), theftCounts[port])
+
+	if total < minUsablePorts {
+		logf("WARN", "only %d usable ports left in the port block after blacklisting %d ports, below the minimum of %d", total, len(blacklist), minUsablePorts)
+	}
+}
+
+// Blacklisted returns the ports that have been blacklisted after repeated
+// theft, see SetTheftBlacklistThreshold, in ascending order.
+func Blacklisted() []int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	ports := make([]int, 0, len(blacklist))
+	for port := range blacklist {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports
+}
@@ -0,0 +1,75 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"time"
+)
+
+// Tuning of the theft circuit breaker, see SetTheftCircuitBreaker. They are
+// variables so tests can shorten them.
+var (
+	breakerWindow     = 10 * time.Second
+	breakerCooldown   = 30 * time.Second
+	breakerMinSamples = 10
+)
+
+// State of the theft circuit breaker. It is guarded by mu.
+var (
+	breakerWindowStart time.Time
+	breakerChecks      int
+	breakerThefts      int
+	breakerOpenUntil   time.Time
+	breakerRate        float64
+)
+
+// recordVerifyLocked feeds the outcome of verifying a candidate port to the
+// theft circuit breaker and trips it if the theft rate in the current window
+// reaches the threshold. It must be called with mu held.
+func recordVerifyLocked(stolen bool) {
+	if theftBreakerThreshold <= 0 || !breakerOpenUntil.IsZero() {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(breakerWindowStart) > breakerWindow {
+		breakerWindowStart, breakerChecks, breakerThefts = now, 0, 0
+	}
+	breakerChecks++
+	if stolen {
+		breakerThefts++
+	}
+
+	rate := float64(breakerThefts) / float64(breakerChecks)
+	if breakerChecks >= breakerMinSamples && rate >= theftBreakerThreshold {
+		breakerOpenUntil = now.Add(breakerCooldown)
+		breakerRate = rate
+		logf("WARN", "%d of the last %d candidate ports were stolen; failing takes for %v", breakerThefts, breakerChecks, breakerCooldown)
+	}
+}
+
+// breakerErrorLocked returns an ErrSaturated error while the theft circuit
+// breaker is tripped, and resets it once the cooldown has passed. It must be
+// called with mu held.
+func breakerErrorLocked() error {
+	if breakerOpenUntil.IsZero() {
+		return nil
+	}
+	if time.Now().After(breakerOpenUntil) {
+		logf("INFO", "theft circuit breaker reset after %v", breakerCooldown)
+		resetBreakerLocked()
+		return nil
+	}
+	return fmt.Errorf("%w (theft rate %.0f%%)", ErrSaturated, breakerRate*100)
+}
+
+// resetBreakerLocked closes the theft circuit breaker and starts a new
+// window. It must be called with mu held.
+func resetBreakerLocked() {
+	breakerWindowStart = time.Time{}
+	breakerChecks, breakerThefts = 0, 0
+	breakerOpenUntil = time.Time{}
+	breakerRate = 0
+}
@@ -0,0 +1,45 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTheftCircuitBreaker(t *testing.T) {
+	defer reset()
+	defer SetTheftCircuitBreaker(0)
+	defer func(samples int, cooldown time.Duration) {
+		breakerMinSamples, breakerCooldown = samples, cooldown
+	}(breakerMinSamples, breakerCooldown)
+	breakerMinSamples, breakerCooldown = 4, 200*time.Millisecond
+
+	ft := &fakeT{name: "saturated"}
+	SetVerifierForTesting(ft, func(port int) (bool, error) { return false, nil })
+	SetTheftCircuitBreaker(0.5)
+
+	_, err := Take(1)
+	assert.True(t, errors.Is(err, ErrSaturated))
+	assert.EqualError(t, err, "freeport: host appears saturated (theft rate 100%)")
+	_, _, _, _, numThefts := Counters()
+	assert.Equal(t, uint64(4), numThefts, "expected the breaker to stop compensating")
+
+	// The breaker fails fast without looking at more candidates.
+	_, err = Take(1)
+	assert.True(t, errors.Is(err, ErrSaturated))
+	_, _, _, _, numThefts = Counters()
+	assert.Equal(t, uint64(4), numThefts)
+
+	// After the cooldown takes go through again.
+	ft.runCleanups()
+	time.Sleep(breakerCooldown)
+	ports, err := Take(1)
+	require.NoError(t, err)
+	Return(ports)
+}
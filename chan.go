@@ -0,0 +1,35 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "sync"
+
+// TakeChan takes n free ports and delivers them on a buffered channel, which
+// is closed once all ports have been received, for pipeline style code where
+// stages pull ports as they need them. The returned cleanup function returns
+// all n ports to the pool, whether or not they were received from the
+// channel, and may be called more than once.
+func TakeChan(n int) (<-chan int, func(), error) {
+	ports, err := Take(n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan int, len(ports))
+	for _, port := range ports {
+		ch <- port
+	}
+	close(ch)
+
+	var once sync.Once
+	cleanup := func() {
+		once.Do(func() {
+			for range ch {
+				// drain so that late receivers don't use returned ports
+			}
+			Return(ports)
+		})
+	}
+	return ch, cleanup, nil
+}
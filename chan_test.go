@@ -0,0 +1,34 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeChan(t *testing.T) {
+	defer reset()
+	reset()
+
+	ch, cleanup, err := TakeChan(4)
+	require.NoError(t, err)
+
+	first, second := <-ch, <-ch
+	assert.NotEqual(t, first, second)
+	assert.Len(t, TakenPorts(), 4)
+
+	cleanup()
+	cleanup()
+	assert.Empty(t, TakenPorts())
+	_, ok := <-ch
+	assert.False(t, ok, "expected channel to be drained and closed")
+
+	_, returnCalls, _, numReturned, _ := Counters()
+	assert.Equal(t, uint64(1), returnCalls)
+	assert.Equal(t, uint64(4), numReturned)
+	waitForStatsReset(t)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "sync"
+
+// Collector accumulates ports taken over the course of a test and returns all
+// of them to the pool with a single Return when the test ends.
+type Collector struct {
+	t TestingT
+
+	mu    sync.Mutex
+	ports []int
+}
+
+// NewCollector returns a Collector whose ports are returned to the pool when
+// the test ends.
+func NewCollector(t TestingT) *Collector {
+	t.Helper()
+	c := &Collector{t: t}
+	t.Cleanup(c.returnAll)
+	return c
+}
+
+// Take returns n free ports from the reserved port block and records them for
+// return when the test ends. If Take fails, ports collected by earlier calls
+// are still returned at the end of the test. See Take for more details.
+func (c *Collector) Take(n int) ([]int, error) {
+	ports, err := Take(n)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	for _, p := range ports {
+		portLastUser[p] = c.t.Name()
+	}
+	mu.Unlock()
+
+	c.mu.Lock()
+	c.ports = append(c.ports, ports...)
+	c.mu.Unlock()
+	return ports, nil
+}
+
+// Ports returns a copy of all ports collected so far.
+func (c *Collector) Ports() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.ports...)
+}
+
+func (c *Collector) returnAll() {
+	c.mu.Lock()
+	ports := c.ports
+	c.ports = nil
+	c.mu.Unlock()
+
+	Return(ports)
+	if len(ports) > 0 {
+		logf("DEBUG", "Test %q returned ports %v", c.t.Name(), logPorts(ports))
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeT is a TestingT that records cleanups so tests can run them on demand.
+type fakeT struct {
+	name     string
+	cleanups []func()
+	failed   string
+}
+
+func (f *fakeT) Cleanup(fn func()) { f.cleanups = append(f.cleanups, fn) }
+func (f *fakeT) Helper()           {}
+func (f *fakeT) Name() string      { return f.name }
+func (f *fakeT) Fatalf(format string, args ...interface{}) {
+	f.failed = fmt.Sprintf(format, args...)
+}
+
+func (f *fakeT) runCleanups() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+	f.cleanups = nil
+}
+
+func TestCollector(t *testing.T) {
+	defer reset()
+
+	ft := &fakeT{name: "collector"}
+	c := NewCollector(ft)
+	require.Len(t, ft.cleanups, 1)
+
+	first, err := c.Take(2)
+	require.NoError(t, err)
+	second, err := c.Take(3)
+	require.NoError(t, err)
+
+	// A failed Take must not lose the ports collected so far.
+	_, err = c.Take(-1)
+	require.Error(t, err)
+
+	all := append(append([]int(nil), first...), second...)
+	assert.Equal(t, all, c.Ports())
+
+	numTotal, numPending, numFree := stats()
+	assert.Equal(t, numTotal-len(all), numFree+numPending)
+
+	ft.runCleanups()
+	assert.Empty(t, c.Ports())
+	assert.Equal(t, numTotal, waitForStatsReset(t))
+}
@@ -0,0 +1,27 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+// GetFreePort takes a single port from the reserved block. It has the
+// signature of GetFreePort in github.com/phayes/freeport so that callers can
+// switch by changing the import path and get ports that don't collide with
+// other processes using freeport.
+//
+// Like its phayes counterpart, the port need not be returned: it stays taken
+// until the process exits, when the whole block is released. Callers that take
+// many ports over a long run should use Take and Return, or GetN in tests,
+// instead so that the block is not exhausted.
+func GetFreePort() (int, error) {
+	ports, err := Take(1)
+	if err != nil {
+		return 0, err
+	}
+	return ports[0], nil
+}
+
+// GetFreePorts is like GetFreePort but takes count ports, matching
+// GetFreePorts in github.com/phayes/freeport. The ports need not be returned.
+func GetFreePorts(count int) ([]int, error) {
+	return Take(count)
+}
@@ -0,0 +1,26 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetFreePorts(t *testing.T) {
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+	defer reset()
+
+	port, err := GetFreePort()
+	require.NoError(t, err)
+	ports, err := GetFreePorts(2)
+	require.NoError(t, err)
+	assert.NotContains(t, ports, port)
+	assert.ElementsMatch(t, append(ports, port), TakenPorts())
+
+	_, err = GetFreePorts(8)
+	assert.ErrorIs(t, err, ErrBlockTooSmall)
+}
@@ -0,0 +1,78 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WriteConfigMapYAML writes a Kubernetes ConfigMap manifest named name to path
+// with one data entry per key in data, e.g. for tests that hand allocated
+// ports to services in a kind or minikube cluster. Port values are written as
+// strings, as ConfigMap data requires. The file is replaced atomically.
+func WriteConfigMapYAML(path, name string, data map[string]int) error {
+	if !validConfigMapName(name) {
+		return fmt.Errorf("freeport: invalid ConfigMap name %q", name)
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		if !validConfigMapKey(key) {
+			return fmt.Errorf("freeport: invalid ConfigMap key %q", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.WriteString("apiVersion: v1\nkind: ConfigMap\nmetadata:\n")
+	fmt.Fprintf(&buf, "  name: %s\n", name)
+	if len(keys) == 0 {
+		buf.WriteString("data: {}\n")
+	} else {
+		buf.WriteString("data:\n")
+	}
+	// Keys and values are quoted so that YAML doesn't read keys such as "true"
+	// or the port numbers as anything but strings.
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "  %q: %q\n", key, fmt.Sprint(data[key]))
+	}
+
+	return writeFileAtomic(path, buf.Bytes(), 0o644)
+}
+
+// validConfigMapName reports whether name is a valid DNS subdomain, as
+// Kubernetes requires of object names.
+func validConfigMapName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validConfigMapKey reports whether key may be used as a ConfigMap data key.
+func validConfigMapKey(key string) bool {
+	if key == "" || len(key) > 253 || key == "." || key == ".." {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
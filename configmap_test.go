@@ -0,0 +1,37 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteConfigMapYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.yaml")
+	require.NoError(t, WriteConfigMapYAML(path, "test-ports", map[string]int{"API_PORT": 20002, "db.port": 20001}))
+
+	out, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-ports
+data:
+  "API_PORT": "20002"
+  "db.port": "20001"
+`, string(out))
+
+	require.NoError(t, WriteConfigMapYAML(path, "empty", nil))
+	out, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "data: {}\n")
+
+	assert.Error(t, WriteConfigMapYAML(path, "Bad_Name", nil))
+	assert.Error(t, WriteConfigMapYAML(path, "ok", map[string]int{"bad key": 1}))
+}
@@ -0,0 +1,267 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+)
+
+// PortRange is a block of Count consecutive ports starting at Base.
+type PortRange struct {
+	Base  int
+	Count int
+}
+
+// Contains reports whether port is part of the range.
+func (r PortRange) Contains(port int) bool {
+	return port >= r.Base && port < r.Base+r.Count
+}
+
+// Slice returns the ports of the range in ascending order.
+func (r PortRange) Slice() []int {
+	ports := make([]int, r.Count)
+	for i := range ports {
+		ports[i] = r.Base + i
+	}
+	return ports
+}
+
+// TakeContiguous returns n consecutive free ports from the reserved port
+// block. Unlike Take it does not wait for ports to be returned; it fails if
+// the free ports are too fragmented to satisfy the request.
+func TakeContiguous(n int) (PortRange, error) {
+	if n <= 0 {
+		return PortRange{}, invalidCountError(n)
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	r, err := takeContiguousLocked(n)
+	if err == nil {
+		countTake(r.Slice())
+	}
+	return r, err
+}
+
+// ReturnRange returns the ports of a range taken with TakeContiguous to the
+// pool.
+func ReturnRange(r PortRange) {
+	Return(r.Slice())
+}
+
+// TakeBase is like TakeContiguous but returns only the first port of the
+// range, for services configured with a single base port from which they
+// derive base+0 through base+count-1. Return the ports with ReturnBase.
+func TakeBase(count int) (base int, err error) {
+	r, err := TakeContiguous(count)
+	if err != nil {
+		return 0, err
+	}
+	return r.Base, nil
+}
+
+// ReturnBase returns the count ports starting at base, taken with TakeBase, to
+// the pool.
+func ReturnBase(base, count int) {
+	ReturnRange(PortRange{Base: base, Count: count})
+}
+
+// takeContiguousLocked implements TakeContiguous. It must be called with mu
+// held and after the package has been initialized.
+func takeContiguousLocked(n int) (PortRange, error) {
+	if n > total-reserveFree {
+		return PortRange{}, tooSmallErrorLocked(n, reserveFree)
+	}
+	if freePorts.Len()-n < reserveFree {
+		return PortRange{}, fmt.Errorf("freeport: no %d contiguous free ports available outside of the reserve", n)
+	}
+
+	for {
+		elems := findRunLocked(n)
+		if elems == nil {
+			return PortRange{}, fmt.Errorf("freeport: no %d contiguous free ports available", n)
+		}
+
+		stolen := false
+		for _, elem := range elems {
+			freePorts.Remove(elem)
+		}
+		for _, elem := range elems {
+			if port := elem.Value.(int); verifyLocked(port) != nil {
+				stolenLocked(port)
+				stolen = true
+			}
+		}
+		if stolen {
+			// put the ports that are still free back and look for another run
+			for i := len(elems) - 1; i >= 0; i-- {
+				if port := elems[i].Value.(int); !isLostLocked(port) {
+					freePorts.PushFront(port)
+				}
+			}
+			continue
+		}
+
+		r := PortRange{Base: elems[0].Value.(int), Count: n}
+		for _, port := range r.Slice() {
+			handOutLocked(port)
+		}
+		updateMaxUsedLocked()
+		return r, nil
+	}
+}
+
+// TakeBestEffortContiguous returns n free ports from the reserved port block,
+// as contiguous as the free ports allow: ports[:contiguous] is the longest
+// available run of consecutive ports, up to n, and the remaining ports are
+// arbitrary free ports. Like TakeContiguous it does not wait for ports to be
+// returned; it fails only if fewer than n ports are free.
+func TakeBestEffortContiguous(n int) (ports []int, contiguous int, err error) {
+	if n <= 0 {
+		return nil, 0, invalidCountError(n)
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	if n > total-reserveFree {
+		return nil, 0, tooSmallErrorLocked(n, reserveFree)
+	}
+
+	var run []int
+	for run == nil {
+		if freePorts.Len()-n < reserveFree {
+			return nil, 0, fmt.Errorf("freeport: fewer than %d free ports available outside of the reserve", n)
+		}
+
+		elems := findLongestRunLocked(n)
+		run = make([]int, 0, len(elems))
+		for _, elem := range elems {
+			freePorts.Remove(elem)
+		}
+		for _, elem := range elems {
+			if port := elem.Value.(int); verifyLocked(port) != nil {
+				stolenLocked(port)
+				run = nil
+			}
+			if run != nil {
+				run = append(run, elem.Value.(int))
+			}
+		}
+		if run == nil {
+			for i := len(elems) - 1; i >= 0; i-- {
+				if port := elems[i].Value.(int); !isLostLocked(port) {
+					freePorts.PushFront(port)
+				}
+			}
+		}
+	}
+	for _, port := range run {
+		handOutLocked(port)
+	}
+
+	ports = run
+	for len(ports) < n {
+		if freePorts.Len() <= reserveFree {
+			for _, port := range ports {
+				putBackLocked(port)
+			}
+			return nil, 0, fmt.Errorf("freeport: fewer than %d free ports available outside of the reserve", n)
+		}
+		elem := freePorts.Front()
+		freePorts.Remove(elem)
+		port := elem.Value.(int)
+		if verifyLocked(port) != nil {
+			stolenLocked(port)
+			continue
+		}
+		handOutLocked(port)
+		ports = append(ports, port)
+	}
+
+	updateMaxUsedLocked()
+	countTake(ports)
+	return ports, len(run), nil
+}
+
+// findLongestRunLocked returns the free list elements of the longest run of
+// consecutive free ports, capped at max ports. Of several runs of the same
+// length the lowest wins. It must be called with mu held and the free list
+// must not be empty.
+func findLongestRunLocked(max int) []*list.Element {
+	byPort := make(map[int]*list.Element, freePorts.Len())
+	ports := make([]int, 0, freePorts.Len())
+	for elem := freePorts.Front(); elem != nil; elem = elem.Next() {
+		port := elem.Value.(int)
+		byPort[port] = elem
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	best, bestLen := 0, 0
+	for start := 0; start < len(ports) && bestLen < max; {
+		end := start + 1
+		for end < len(ports) && ports[end] == ports[end-1]+1 && end-start < max {
+			end++
+		}
+		if end-start > bestLen {
+			best, bestLen = start, end-start
+		}
+		start = end
+	}
+
+	elems := make([]*list.Element, bestLen)
+	for i := range elems {
+		elems[i] = byPort[ports[best+i]]
+	}
+	return elems
+}
+
+// findRunLocked returns the free list elements of the lowest run of n
+// consecutive free ports, or nil if there is none. It must be called with mu
+// held.
+func findRunLocked(n int) []*list.Element {
+	byPort := make(map[int]*list.Element, freePorts.Len())
+	ports := make([]int, 0, freePorts.Len())
+	for elem := freePorts.Front(); elem != nil; elem = elem.Next() {
+		port := elem.Value.(int)
+		byPort[port] = elem
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	for i := range ports {
+		if i+n > len(ports) {
+			break
+		}
+		if ports[i+n-1]-ports[i] != n-1 {
+			continue
+		}
+		elems := make([]*list.Element, n)
+		for j := range elems {
+			elems[j] = byPort[ports[i+j]]
+		}
+		return elems
+	}
+	return nil
+}
+
+// isLostLocked reports whether port has been removed from circulation because
+// it was stolen. It must be called with mu held.
+func isLostLocked(port int) bool {
+	if _, ok := lostPorts[port]; ok {
+		return true
+	}
+	_, ok := blacklist[port]
+	return ok
+}
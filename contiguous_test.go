@@ -0,0 +1,122 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPortRange(t *testing.T) {
+	r := PortRange{Base: 20000, Count: 3}
+	assert.Equal(t, []int{20000, 20001, 20002}, r.Slice())
+	assert.True(t, r.Contains(20000))
+	assert.True(t, r.Contains(20002))
+	assert.False(t, r.Contains(19999))
+	assert.False(t, r.Contains(20003))
+}
+
+func TestTakeContiguous(t *testing.T) {
+	defer reset()
+
+	_, err := TakeContiguous(0)
+	require.Error(t, err)
+
+	// Steal a port in the middle of the first run so that it is skipped.
+	ports, err := Take(1)
+	require.NoError(t, err)
+	Return(ports)
+	numTotal := waitForStatsReset(t)
+	free := peekAllFree()
+	leaky, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", free[2]))
+	require.NoError(t, err)
+	defer leaky.Close()
+
+	r, err := TakeContiguous(4)
+	require.NoError(t, err)
+	assert.False(t, r.Contains(free[2]))
+	for _, port := range r.Slice() {
+		assert.NotContains(t, peekAllFree(), port)
+	}
+
+	_, err = TakeContiguous(numTotal)
+	require.Error(t, err)
+
+	ReturnRange(r)
+	assert.Equal(t, numTotal-1, waitForStatsReset(t))
+}
+
+func TestTakeBestEffortContiguous(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	_, _, err := TakeBestEffortContiguous(0)
+	require.Error(t, err)
+
+	// Fragment the free ports into 30001-30002, 30004-30005 and 30007.
+	mu.Lock()
+	once.Do(initialize)
+	require.True(t, takePortLocked(30003))
+	require.True(t, takePortLocked(30006))
+	mu.Unlock()
+
+	ports, contiguous, err := TakeBestEffortContiguous(4)
+	require.NoError(t, err)
+	require.Len(t, ports, 4)
+	assert.Equal(t, 2, contiguous)
+	assert.Equal(t, []int{30001, 30002}, ports[:contiguous])
+	assert.Len(t, peekAllFree(), 1)
+
+	// Fewer free ports than requested.
+	_, _, err = TakeBestEffortContiguous(2)
+	require.Error(t, err)
+	assert.Len(t, peekAllFree(), 1)
+
+	ports, contiguous, err = TakeBestEffortContiguous(1)
+	require.NoError(t, err)
+	assert.Equal(t, []int{30007}, ports)
+	assert.Equal(t, 1, contiguous)
+}
+
+func TestTakeBase(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	base, err := TakeBase(3)
+	require.NoError(t, err)
+	assert.Equal(t, 30001, base)
+	assert.Equal(t, []int{30001, 30002, 30003}, TakenPorts())
+
+	_, err = TakeBase(5)
+	require.Error(t, err)
+
+	ReturnBase(base, 3)
+	assert.Empty(t, TakenPorts())
+}
+
+func TestTakeContiguousVerifier(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	// The custom verifier vets contiguous runs like any other candidate.
+	ft := &fakeT{name: "contiguous"}
+	defer ft.runCleanups()
+	SetVerifierForTesting(ft, func(port int) (bool, error) { return port != 30002, nil })
+
+	r, err := TakeContiguous(3)
+	require.NoError(t, err)
+	assert.Equal(t, PortRange{Base: 30003, Count: 3}, r)
+	numTotal, _, _ := stats()
+	assert.Equal(t, 6, numTotal, "the rejected port must be removed from circulation")
+	ReturnRange(r)
+
+	reset()
+	ports, contiguous, err := TakeBestEffortContiguous(4)
+	require.NoError(t, err)
+	assert.Equal(t, []int{30003, 30004, 30005, 30006}, ports)
+	assert.Equal(t, 4, contiguous)
+}
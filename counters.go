@@ -0,0 +1,124 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "sync/atomic"
+
+// Cumulative counters of pool activity, see Counters.
+var (
+	takes         atomic.Uint64
+	returns       atomic.Uint64
+	portsTaken    atomic.Uint64
+	portsReturned atomic.Uint64
+	thefts        atomic.Uint64
+)
+
+// Reasons used by freeport itself for returned ports, see ReturnWithReason.
+const (
+	ReasonUnspecified  = "unspecified"
+	ReasonLeaseExpired = "lease expired"
+	ReasonSoftRevoked  = "soft reservation revoked"
+)
+
+// returnReasons counts returned ports by reason. It is guarded by mu.
+var returnReasons map[string]uint64
+
+// Counters returns cumulative counts of pool activity since initialization:
+// the number of successful calls that took ports and the number of calls that
+// returned ports, the number of ports taken and returned by them, and the
+// number of ports found to have been stolen. Unlike stats, which describes the
+// pool at a single instant, sampling Counters over time shows the flow of
+// ports, e.g. takes growing without matching returns.
+func Counters() (takeCalls, returnCalls, numTaken, numReturned, numThefts uint64) {
+	return takes.Load(), returns.Load(), portsTaken.Load(), portsReturned.Load(), thefts.Load()
+}
+
+// countTake counts a successful take. It must be called with mu held.
+func countTake(ports []int) {
+	takes.Add(1)
+	portsTaken.Add(uint64(len(ports)))
+	queueHookLocked(true, ports)
+}
+
+// countReturnLocked counts a return of n ports. It must be called with mu
+// held.
+func countReturnLocked(n int, reason string) {
+	if n == 0 {
+		return
+	}
+	returns.Add(1)
+	portsReturned.Add(uint64(n))
+	if returnReasons == nil {
+		returnReasons = make(map[string]uint64)
+	}
+	returnReasons[reason] += uint64(n)
+}
+
+// ReturnReasons returns the number of ports returned for each reason passed to
+// ReturnWithReason since initialization. Ports returned with Return are
+// counted as ReasonUnspecified.
+func ReturnReasons() map[string]uint64 {
+	mu.Lock()
+	defer mu.Unlock()
+
+	out := make(map[string]uint64, len(returnReasons))
+	for reason, n := range returnReasons {
+		out[reason] = n
+	}
+	return out
+}
+
+func resetCounters() {
+	takes.Store(0)
+	returns.Store(0)
+	portsTaken.Store(0)
+	portsReturned.Store(0)
+	thefts.Store(0)
+	returnReasons = nil
+}
+
+// Snapshot is a token returned by BeginSnapshot that records the counters at
+// a point in time.
+type Snapshot struct {
+	takeCalls, returnCalls, numTaken, numReturned, numThefts uint64
+	outstanding                                              int
+}
+
+// SnapshotDiff is the pool activity between BeginSnapshot and EndSnapshot.
+// Outstanding is the change in the number of taken ports, so a positive value
+// at the end of a test points at ports that were not returned.
+type SnapshotDiff struct {
+	TakeCalls, ReturnCalls uint64
+	PortsTaken             uint64
+	PortsReturned          uint64
+	Thefts                 uint64
+	Outstanding            int
+}
+
+// BeginSnapshot records the current counters, see EndSnapshot. Snapshots are
+// plain values, so any number of them may be in use at the same time. The
+// counters are global to the process though, so the diff of a test includes
+// the activity of any tests running in parallel with it.
+func BeginSnapshot() Snapshot {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var s Snapshot
+	s.takeCalls, s.returnCalls, s.numTaken, s.numReturned, s.numThefts = Counters()
+	s.outstanding = len(taken)
+	return s
+}
+
+// EndSnapshot returns the pool activity since BeginSnapshot returned s.
+func EndSnapshot(s Snapshot) SnapshotDiff {
+	end := BeginSnapshot()
+	return SnapshotDiff{
+		TakeCalls:     end.takeCalls - s.takeCalls,
+		ReturnCalls:   end.returnCalls - s.returnCalls,
+		PortsTaken:    end.numTaken - s.numTaken,
+		PortsReturned: end.numReturned - s.numReturned,
+		Thefts:        end.numThefts - s.numThefts,
+		Outstanding:   end.outstanding - s.outstanding,
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounters(t *testing.T) {
+	defer reset()
+	reset()
+
+	first, err := Take(3)
+	require.NoError(t, err)
+	second, err := Take(2)
+	require.NoError(t, err)
+	Return(first)
+
+	takeCalls, returnCalls, numTaken, numReturned, numThefts := Counters()
+	assert.Equal(t, uint64(2), takeCalls)
+	assert.Equal(t, uint64(1), returnCalls)
+	assert.Equal(t, uint64(5), numTaken)
+	assert.Equal(t, uint64(3), numReturned)
+	assert.Equal(t, uint64(0), numThefts)
+
+	leaky, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", peekFree()))
+	require.NoError(t, err)
+	defer leaky.Close()
+	third, err := Take(1)
+	require.NoError(t, err)
+	Return(append(second, third...))
+
+	takeCalls, returnCalls, numTaken, numReturned, numThefts = Counters()
+	assert.Equal(t, uint64(3), takeCalls)
+	assert.Equal(t, uint64(2), returnCalls)
+	assert.Equal(t, uint64(6), numTaken)
+	assert.Equal(t, uint64(6), numReturned)
+	assert.Equal(t, uint64(1), numThefts)
+}
+
+func TestReturnWithReason(t *testing.T) {
+	defer reset()
+	reset()
+
+	ports, err := Take(6)
+	require.NoError(t, err)
+
+	Return(ports[:1])
+	ReturnWithReason(ports[1:3], "passed")
+	ReturnWithReason(ports[3:], "failed")
+	ReturnWithReason(ports[3:], "failed") // already returned
+
+	assert.Equal(t, map[string]uint64{
+		ReasonUnspecified: 1,
+		"passed":          2,
+		"failed":          3,
+	}, ReturnReasons())
+	waitForStatsReset(t)
+}
+
+func TestSnapshot(t *testing.T) {
+	defer reset()
+	reset()
+
+	kept, err := Take(2)
+	require.NoError(t, err)
+	defer Return(kept)
+
+	outer := BeginSnapshot()
+	leaked, err := Take(3)
+	require.NoError(t, err)
+
+	inner := BeginSnapshot()
+	ports, err := Take(1)
+	require.NoError(t, err)
+	Return(ports)
+	assert.Equal(t, SnapshotDiff{TakeCalls: 1, ReturnCalls: 1, PortsTaken: 1, PortsReturned: 1}, EndSnapshot(inner))
+
+	assert.Equal(t, SnapshotDiff{TakeCalls: 2, ReturnCalls: 1, PortsTaken: 4, PortsReturned: 1, Outstanding: 3}, EndSnapshot(outer))
+	Return(leaked)
+}
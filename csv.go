@@ -0,0 +1,56 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TakeCSV is like Take but also returns the ports formatted as a
+// comma-separated list, e.g. for passing to a subprocess via a single flag.
+func TakeCSV(n int) (string, []int, error) {
+	ports, err := Take(n)
+	if err != nil {
+		return "", nil, err
+	}
+	return formatCSV(ports), ports, nil
+}
+
+// ReturnCSV parses a comma-separated list of ports as produced by TakeCSV and
+// returns them to the pool. If any entry is malformed no ports are returned.
+func ReturnCSV(csv string) error {
+	ports, err := parseCSV(csv)
+	if err != nil {
+		return err
+	}
+	Return(ports)
+	return nil
+}
+
+func formatCSV(ports []int) string {
+	fields := make([]string, len(ports))
+	for i, port := range ports {
+		fields[i] = strconv.Itoa(port)
+	}
+	return strings.Join(fields, ",")
+}
+
+func parseCSV(csv string) ([]int, error) {
+	if strings.TrimSpace(csv) == "" {
+		return nil, nil
+	}
+
+	fields := strings.Split(csv, ",")
+	ports := make([]int, 0, len(fields))
+	for _, field := range fields {
+		port, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("freeport: invalid port %q in %q", field, csv)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeCSV(t *testing.T) {
+	defer reset()
+
+	csv, ports, err := TakeCSV(3)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%d,%d,%d", ports[0], ports[1], ports[2]), csv)
+
+	assert.EqualError(t, ReturnCSV(csv+",nope"), fmt.Sprintf("freeport: invalid port %q in %q", "nope", csv+",nope"))
+	_, numPending, _ := stats()
+	assert.Equal(t, 0, numPending)
+
+	require.NoError(t, ReturnCSV(csv))
+	waitForStatsReset(t)
+}
+
+func TestParseCSV(t *testing.T) {
+	cases := []struct {
+		in    string
+		ports []int
+		err   bool
+	}{
+		{"", nil, false},
+		{"10001", []int{10001}, false},
+		{"10001, 10002,10003", []int{10001, 10002, 10003}, false},
+		{"10001,,10003", nil, true},
+		{"10001,x", nil, true},
+		{"0", nil, true},
+		{"65536", nil, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			ports, err := parseCSV(tc.in)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.ports, ports)
+		})
+	}
+}
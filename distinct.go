@@ -0,0 +1,71 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "fmt"
+
+// TakeDistinctFrom is like Take but never returns any of the ports in
+// previous, e.g. the ports of a service instance that is being restarted, so
+// that the new instance does not run into connections lingering in TIME_WAIT
+// on the old ports. The ports in previous may still be held by the caller.
+// Unlike Take it does not wait for ports to be returned; it fails if the free
+// ports cannot avoid previous.
+func TakeDistinctFrom(n int, previous []int) ([]int, error) {
+	if n <= 0 {
+		return nil, invalidCountError(n)
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	exclude := make(map[int]struct{}, len(previous))
+	for _, port := range previous {
+		exclude[port] = struct{}{}
+	}
+	ports, found := takeFilteredLocked(n, func(port int) bool {
+		_, ok := exclude[port]
+		return !ok
+	})
+	if ports == nil {
+		return nil, fmt.Errorf("freeport: cannot take %d ports distinct from %d previous ports; only %d free ports qualify", n, len(previous), found)
+	}
+
+	sortResultsLocked(ports)
+	countTake(ports)
+	return ports, nil
+}
+
+// takeFilteredLocked takes n free ports for which keep returns true, without
+// waiting and without dipping into the reserve. If there are not enough such
+// ports it takes none and returns nil together with the number of qualifying
+// ports it found. It must be called with mu held and after the package has
+// been initialized.
+func takeFilteredLocked(n int, keep func(port int) bool) ([]int, int) {
+	var candidates []int
+	for elem := freePorts.Front(); elem != nil; elem = elem.Next() {
+		if port := elem.Value.(int); keep(port) {
+			candidates = append(candidates, port)
+		}
+	}
+
+	ports := make([]int, 0, n)
+	for _, port := range candidates {
+		if len(ports) == n || freePorts.Len() <= reserveFree {
+			break
+		}
+		if takePortLocked(port) {
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) < n {
+		for _, port := range ports {
+			putBackLocked(port)
+		}
+		return nil, len(ports)
+	}
+	return ports, n
+}
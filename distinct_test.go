@@ -0,0 +1,35 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeDistinctFrom(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	previous, err := Take(3)
+	require.NoError(t, err)
+	Return(previous)
+
+	// Make the previous ports free again, at the back of the free list.
+	assert.Equal(t, 7, waitForStatsReset(t))
+
+	ports, err := TakeDistinctFrom(4, previous)
+	require.NoError(t, err)
+	for _, port := range ports {
+		assert.NotContains(t, previous, port)
+	}
+
+	// Only the previous ports are left.
+	_, err = TakeDistinctFrom(1, previous)
+	assert.Error(t, err)
+	assert.ElementsMatch(t, ports, TakenPorts())
+	Return(ports)
+}
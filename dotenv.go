@@ -0,0 +1,103 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteDotEnv writes vars as KEY=port lines to the .env file at path, e.g. for
+// docker-compose. Existing lines for other keys, comments and blank lines are
+// preserved; existing lines for keys in vars are updated in place and new keys
+// are appended in sorted order. The file is replaced atomically.
+func WriteDotEnv(path string, vars map[string]int) error {
+	for key := range vars {
+		if key == "" || strings.ContainsAny(key, "=\n \t") {
+			return fmt.Errorf("freeport: invalid .env key %q", key)
+		}
+	}
+
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var buf bytes.Buffer
+	written := make(map[string]bool, len(vars))
+	scanner := bufio.NewScanner(bytes.NewReader(existing))
+	for scanner.Scan() {
+		line := scanner.Text()
+		key := dotEnvKey(line)
+		if port, ok := vars[key]; ok && !written[key] {
+			fmt.Fprintf(&buf, "%s=%d\n", key, port)
+			written[key] = true
+			continue
+		}
+		buf.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		if !written[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		buf.WriteString(key + "=" + strconv.Itoa(vars[key]) + "\n")
+	}
+
+	return writeFileAtomic(path, buf.Bytes(), 0o644)
+}
+
+// TakeDotEnv takes one port per key and writes them to the .env file at path
+// with WriteDotEnv. If the file cannot be written the ports are returned to
+// the pool.
+func TakeDotEnv(path string, keys []string) (map[string]int, error) {
+	ports, err := Take(len(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]int, len(keys))
+	for i, key := range keys {
+		vars[key] = ports[i]
+	}
+	if len(vars) != len(keys) {
+		Return(ports)
+		return nil, fmt.Errorf("freeport: duplicate .env keys in %v", keys)
+	}
+
+	if err := WriteDotEnv(path, vars); err != nil {
+		Return(ports)
+		return nil, err
+	}
+	return vars, nil
+}
+
+// dotEnvKey returns the key assigned by a .env line, or "" if the line is not
+// an assignment.
+func dotEnvKey(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+	line = strings.TrimPrefix(line, "export ")
+	key, _, found := strings.Cut(line, "=")
+	if !found {
+		return ""
+	}
+	return strings.TrimSpace(key)
+}
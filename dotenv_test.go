@@ -0,0 +1,40 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteDotEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	require.NoError(t, os.WriteFile(path, []byte("# compose settings\nCOMPOSE_PROJECT_NAME=it\nDB_PORT=1\n"), 0o600))
+
+	require.NoError(t, WriteDotEnv(path, map[string]int{"DB_PORT": 20001, "API_PORT": 20002, "AAA_PORT": 20003}))
+
+	out, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "# compose settings\nCOMPOSE_PROJECT_NAME=it\nDB_PORT=20001\nAAA_PORT=20003\nAPI_PORT=20002\n", string(out))
+
+	assert.Error(t, WriteDotEnv(path, map[string]int{"BAD KEY": 1}))
+}
+
+func TestTakeDotEnv(t *testing.T) {
+	defer reset()
+
+	path := filepath.Join(t.TempDir(), ".env")
+	vars, err := TakeDotEnv(path, []string{"DB_PORT", "API_PORT"})
+	require.NoError(t, err)
+	defer Return([]int{vars["DB_PORT"], vars["API_PORT"]})
+
+	out, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("API_PORT=%d\nDB_PORT=%d\n", vars["API_PORT"], vars["DB_PORT"]), string(out))
+}
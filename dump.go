@@ -0,0 +1,109 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Dump writes a human-readable report of the state of the pool to w: the port
+// block, the free, pending and taken ports together with the label of each
+// taken port, the ports removed from circulation and the activity counters.
+// It is meant for triage, e.g. from a failing test or a SIGQUIT handler. The
+// state is captured under a single lock, so the report is consistent, and
+// written after the lock is released. Dump does not initialize the package.
+func Dump(w io.Writer) error {
+	mu.Lock()
+	if firstPort == 0 {
+		mu.Unlock()
+		_, err := io.WriteString(w, "freeport: not initialized\n")
+		return err
+	}
+
+	bases := append([]int{firstPort}, extraBlocks...)
+	size, numTotal, reserve := blockSize, total, reserveFree
+	free := listPorts(freePorts)
+	pending := listPorts(pendingPorts)
+	takenPorts := setPorts(taken)
+	labels := make(map[int]string, len(takenPorts))
+	for _, port := range takenPorts {
+		if label, ok := portLastUser[port]; ok {
+			labels[port] = label
+		}
+	}
+	lost := setPorts(lostPorts)
+	blacklisted := setPorts(blacklist)
+	pinnedPorts := setPorts(pinned)
+	reasons := make(map[string]uint64, len(returnReasons))
+	for reason, n := range returnReasons {
+		reasons[reason] = n
+	}
+	numWaiters := waiters.Len()
+	mu.Unlock()
+
+	takeCalls, returnCalls, numTaken, numReturned, numThefts := Counters()
+
+	var buf bytes.Buffer
+	ranges := make([]string, len(bases))
+	for i, base := range bases {
+		ranges[i] = fmt.Sprintf("[%d, %d]", base, base+size-1)
+	}
+	noun := "port block"
+	if len(bases) > 1 {
+		noun = "port blocks"
+	}
+	fmt.Fprintf(&buf, "freeport: %s %s, %d usable ports, reserve %d\n", noun, strings.Join(ranges, ", "), numTotal, reserve)
+	fmt.Fprintf(&buf, "  free (%d): %v\n", len(free), logPorts(free))
+	fmt.Fprintf(&buf, "  pending (%d): %v\n", len(pending), logPorts(pending))
+	fmt.Fprintf(&buf, "  taken (%d):\n", len(takenPorts))
+	for _, port := range takenPorts {
+		if label, ok := labels[port]; ok {
+			fmt.Fprintf(&buf, "    %v %q\n", logPort(port), label)
+		} else {
+			fmt.Fprintf(&buf, "    %v\n", logPort(port))
+		}
+	}
+	fmt.Fprintf(&buf, "  lost (%d): %v\n", len(lost), logPorts(lost))
+	fmt.Fprintf(&buf, "  blacklisted (%d): %v\n", len(blacklisted), logPorts(blacklisted))
+	fmt.Fprintf(&buf, "  pinned (%d): %v\n", len(pinnedPorts), logPorts(pinnedPorts))
+	fmt.Fprintf(&buf, "  waiting takes: %d\n", numWaiters)
+	fmt.Fprintf(&buf, "  counters: %d takes of %d ports, %d returns of %d ports, %d thefts\n", takeCalls, numTaken, returnCalls, numReturned, numThefts)
+
+	names := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		names = append(names, reason)
+	}
+	sort.Strings(names)
+	for _, reason := range names {
+		fmt.Fprintf(&buf, "  returned %q: %d ports\n", reason, reasons[reason])
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// setPorts returns the ports in set in ascending order.
+func setPorts(set map[int]struct{}) []int {
+	out := make([]int, 0, len(set))
+	for port := range set {
+		out = append(out, port)
+	}
+	sort.Ints(out)
+	return out
+}
+
+// listPorts returns the ports in l, which must be freePorts or pendingPorts,
+// in list order.
+func listPorts(l *list.List) []int {
+	out := make([]int, 0, l.Len())
+	for elem := l.Front(); elem != nil; elem = elem.Next() {
+		out = append(out, elem.Value.(int))
+	}
+	return out
+}
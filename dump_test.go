@@ -0,0 +1,35 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDump(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	var out strings.Builder
+	require.NoError(t, Dump(&out))
+	assert.Equal(t, "freeport: not initialized\n", out.String())
+
+	ports, err := TakeLabeled("TestDump", 2)
+	require.NoError(t, err)
+	Return(ports[1:])
+
+	out.Reset()
+	require.NoError(t, Dump(&out))
+	report := out.String()
+	assert.Contains(t, report, "freeport: port block [30000, 30007], 7 usable ports, reserve 0\n")
+	assert.Contains(t, report, "  taken (1):\n    30001 \"TestDump\"\n")
+	assert.Contains(t, report, "  counters: 1 takes of 2 ports, 1 returns of 1 ports, 0 thefts\n")
+	assert.Contains(t, report, "  returned \"unspecified\": 1 ports\n")
+
+	Return(ports[:1])
+}
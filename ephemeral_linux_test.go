@@ -19,3 +19,24 @@ func TestGetEphemeralPortRange(t *testing.T) {
 	}
 	t.Logf("min=%d, max=%d", min, max)
 }
+
+func TestCheckEphemeralOverlap(t *testing.T) {
+	defer reset()
+
+	overlap, msg := CheckEphemeralOverlap()
+	if overlap {
+		t.Fatalf("expected no overlap before initialization: %s", msg)
+	}
+
+	ports, err := Take(1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer Return(ports)
+
+	overlap, msg = CheckEphemeralOverlap()
+	if overlap {
+		t.Fatalf("expected no overlap after initialization: %s", msg)
+	}
+	t.Log(msg)
+}
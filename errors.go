@@ -0,0 +1,55 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors wrapped by the errors returned from Take and its variants.
+// The wrapping errors add the requested count and the state of the pool; use
+// errors.Is rather than matching on the message.
+var (
+	// ErrInvalidCount means that a non-positive number of ports was
+	// requested.
+	ErrInvalidCount = errors.New("freeport: invalid port count")
+
+	// ErrBlockTooSmall means that more ports were requested than the port
+	// block can ever provide at once.
+	ErrBlockTooSmall = errors.New("freeport: block size too small")
+
+	// ErrExhausted means that the request can no longer be satisfied because
+	// too many ports of the block have been stolen.
+	ErrExhausted = errors.New("freeport: impossible to satisfy request")
+
+	// ErrQuotaExceeded means that TakeAs would leave the identity holding
+	// more ports than its quota allows.
+	ErrQuotaExceeded = errors.New("freeport: quota exceeded")
+
+	// ErrSaturated means that the theft circuit breaker has tripped, see
+	// SetTheftCircuitBreaker.
+	ErrSaturated = errors.New("freeport: host appears saturated")
+
+	// ErrTooManyWaiters means that a take would have had to wait for ports
+	// while SetMaxWaiters callers were already waiting.
+	ErrTooManyWaiters = errors.New("freeport: too many waiters")
+)
+
+func invalidCountError(n int) error {
+	return fmt.Errorf("%w: cannot take %d ports", ErrInvalidCount, n)
+}
+
+// tooSmallErrorLocked returns an ErrBlockTooSmall error for a request of n
+// ports. It must be called with mu held.
+func tooSmallErrorLocked(n, reserve int) error {
+	counts := fmt.Sprintf("free=%d, total=%d", freePorts.Len(), total)
+	if reserve > 0 {
+		counts += fmt.Sprintf(", reserve=%d", reserve)
+	}
+	if disallowed > 0 {
+		counts += fmt.Sprintf(", disallowed=%d", disallowed)
+	}
+	return fmt.Errorf("%w: cannot take %d ports (%s)", ErrBlockTooSmall, n, counts)
+}
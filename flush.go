@@ -0,0 +1,61 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"context"
+	"time"
+)
+
+var (
+	// flushLostAfter is how long FlushPending retries a pending port that is
+	// still in use before it considers the port stolen.
+	flushLostAfter = time.Second
+
+	// flushRetryInterval is the pause between the passes of FlushPending.
+	flushRetryInterval = 50 * time.Millisecond
+)
+
+// FlushPending re-verifies the returned ports right away instead of waiting for
+// the background goroutine and returns once no port is pending, e.g. so that
+// a test can assert on the pool right after returning its ports. Ports that
+// are still in use are retried for a short while to give slow servers time to
+// close them; after that they are removed from circulation as stolen, the same
+// way Take handles them. FlushPending verifies ports even while verification
+// is paused with PauseVerification.
+//
+// If ctx is cancelled before every pending port has been resolved,
+// FlushPending returns ctx.Err() and the remaining ports stay pending. It does
+// nothing if freeport has not been initialized.
+func FlushPending(ctx context.Context) error {
+	lostAt := time.Now().Add(flushLostAfter)
+	for {
+		mu.Lock()
+		if firstPort == 0 {
+			mu.Unlock()
+			return nil
+		}
+		verifyPendingLocked(true)
+		left := pendingPorts.Len()
+		if left > 0 && !time.Now().Before(lostAt) {
+			logf("WARN", "%d pending ports are still in use after %v; removing them from circulation", left, flushLostAfter)
+			for pendingPorts.Len() > 0 {
+				stolenLocked(pendingPorts.Remove(pendingPorts.Front()).(int))
+			}
+			// Waiters that can no longer be satisfied must find out.
+			wakeAllLocked()
+			left = 0
+		}
+		mu.Unlock()
+
+		if left == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(flushRetryInterval):
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushPending(t *testing.T) {
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+	defer reset()
+	defer func(d time.Duration) { flushLostAfter = d }(flushLostAfter)
+	SetReverifyInterval(time.Hour)
+	defer SetReverifyInterval(defaultReverifyInterval)
+
+	require.NoError(t, FlushPending(context.Background()), "flushing an uninitialized pool")
+
+	ports, err := Take(2)
+	require.NoError(t, err)
+	Return(ports)
+	require.NoError(t, FlushPending(context.Background()))
+	numTotal, numPending, numFree := stats()
+	assert.Equal(t, 0, numPending)
+	assert.Equal(t, numTotal, numFree)
+
+	// A port that stays in use keeps the flush going until ctx is done.
+	ports, err = Take(2)
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", ports[0]))
+	require.NoError(t, err)
+	defer ln.Close()
+	Return(ports)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, FlushPending(ctx), context.DeadlineExceeded)
+	_, numPending, _ = stats()
+	assert.Equal(t, 1, numPending)
+
+	// Eventually it is resolved as stolen.
+	flushLostAfter = 100 * time.Millisecond
+	require.NoError(t, FlushPending(context.Background()))
+	numTotal, numPending, numFree = stats()
+	assert.Equal(t, 0, numPending)
+	assert.Equal(t, 6, numTotal)
+	assert.Equal(t, numTotal, numFree)
+	mu.Lock()
+	assert.Contains(t, lostPorts, ports[0])
+	mu.Unlock()
+}
@@ -18,12 +18,16 @@ package freeport
 
 import (
 	"container/list"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -49,9 +53,27 @@ var (
 	// lowPort + effectiveMaxBlocks * blockSize must be less than 65535.
 	effectiveMaxBlocks int
 
+	// ephemeralPortMin and ephemeralPortMax are the bounds of the ephemeral
+	// port range detected during initialization, or zero if unknown.
+	ephemeralPortMin, ephemeralPortMax int
+
 	// firstPort is the first port of the allocated block.
 	firstPort int
 
+	// fixedBlock is set if the block was configured with CL_FREEPORT_RANGE
+	// rather than probed for.
+	fixedBlock bool
+
+	// disallowed is the number of ports of the block rejected by the allow
+	// predicate during initialization.
+	disallowed int
+
+	// extraBlocks are the first ports of the blocks reserved in addition to
+	// the one at firstPort because of SetBlockCount, and extraLocks are their
+	// system-wide locks.
+	extraBlocks []int
+	extraLocks  []net.Listener
+
 	// lockLn is the system-wide mutex for the port block.
 	lockLn net.Listener
 
@@ -59,20 +81,39 @@ var (
 	// - pendingPorts
 	// - freePorts
 	// - total
+	// - maxUsed
+	// - waiters
+	// - leases
+	// - leased
+	// - softReservations
+	// - softHeld
+	// - verificationPaused
+	// - lostPorts
+	// - holds
+	// - taken
+	// - pinned
+	// - verifying
+	// - receipts
+	// - initEnv
+	// - envChangeWarned
+	// - foreignRanges
+	// - unverified
+	// - theftCounts
+	// - blacklist
 	mu sync.Mutex
 
 	// once is used to do the initialization on the first call to retrieve free
 	// ports
 	once sync.Once
 
-	// condNotEmpty is a condition variable to wait for freePorts to be not
-	// empty. Linked to 'mu'
-	condNotEmpty *sync.Cond
-
 	// freePorts is a FIFO of all currently free ports. Take from the front,
 	// and return to the back.
 	freePorts *list.List
 
+	// waiters is a FIFO of *pendingTake, the callers blocked in Take waiting for
+	// free ports.
+	waiters *list.List
+
 	// pendingPorts is a FIFO of recently freed ports that have not yet passed
 	// the not-in-use check.
 	pendingPorts *list.List
@@ -80,6 +121,10 @@ var (
 	// total is the total number of available ports in the block for use.
 	total int
 
+	// maxUsed is the largest number of ports that have been taken at the same
+	// time since initialization.
+	maxUsed int
+
 	// seededRand is a random generator that is pre-seeded from the current time.
 	seededRand *rand.Rand
 
@@ -94,13 +139,98 @@ var (
 	// portLastUser associates ports with a test name in order to debug
 	// which test may be leaking unclosed TCP connections.
 	portLastUser map[int]string
+
+	// leases is the set of outstanding leases, reclaimed by the background
+	// goroutine once they expire.
+	leases map[*Lease]struct{}
+
+	// leased maps the taken ports of outstanding leases to their lease, so
+	// that a lease only returns the ports it still holds.
+	leased map[int]*Lease
+
+	// softReservations is a FIFO of the outstanding soft reservations,
+	// oldest first, which are revoked in that order when Take runs dry.
+	softReservations *list.List
+
+	// softHeld maps the taken ports of outstanding soft reservations to
+	// their reservation, so that a reservation only returns the ports it
+	// still holds.
+	softHeld map[int]*SoftReservation
+
+	// verificationPaused stops the background goroutine from moving pending
+	// ports to the free list.
+	verificationPaused bool
+
+	// lostPorts is the set of ports that have been removed from circulation
+	// because they were stolen.
+	lostPorts map[int]struct{}
+
+	// holds are the listeners kept open on taken ports when
+	// SetHoldDuringTake is enabled.
+	holds map[int]net.Listener
+
+	// taken is the set of ports that have been handed out and not returned
+	// yet. Only taken ports are accepted by Return, which keeps the free and
+	// pending lists free of duplicates.
+	taken map[int]struct{}
+
+	// takenAt records when each taken port was handed out, for
+	// OnReturnWithDuration.
+	takenAt map[int]time.Time
+
+	// pinned is the set of ports that have been pinned with Pin. They are
+	// neither free nor taken.
+	pinned map[int]struct{}
+
+	// verifying is the number of candidate ports that verifyLocked is
+	// checking with mu released. They are neither free nor taken either.
+	verifying int
+
+	// owners maps ports taken with TakeAs to the identity they are charged
+	// to, and quotaUsed counts the ports charged to each identity.
+	owners    map[int]string
+	quotaUsed map[string]int
+
+	// receipts maps the taken ports of allocations made with TakeReceipt to
+	// the allocation ID.
+	receipts map[int]string
+
+	// initEnv holds the values of the configuration environment variables
+	// seen by initialize.
+	initEnv map[string]string
+
+	// envChangeWarned records that a change to initEnv was already reported.
+	envChangeWarned bool
+
+	// theftCounts is the number of times each port has been found stolen.
+	theftCounts map[int]int
+
+	// blacklist is the set of ports that were stolen too often and are
+	// never reclaimed.
+	blacklist map[int]struct{}
+
+	// unverified is the set of free ports that were added to the free list
+	// without being verified because of SetWarmProbeCount.
+	unverified map[int]struct{}
+
+	// foreignRanges are port ranges published by other freeport-based tools
+	// on this host that the port block must not overlap.
+	foreignRanges [][2]int
 )
 
+// envVars are the environment variables read by initialize.
+var envVars = []string{"CL_RESERVE_PORTS", "CL_FREEPORT_MAX_BLOCK", "CL_FREEPORT_RANGE", "FREEPORT_RESERVED_RANGES"}
+
 // initialize is used to initialize freeport.
 func initialize() {
-	var err error
+	initEnv = readEnv()
+	envChangeWarned = false
 
 	blockSize = 2048
+	if portsPerWorker > 0 {
+		blockSize = blockSizeForParallelism(runtime.GOMAXPROCS(0), portsPerWorker)
+		logf("INFO", "using blockSize %d for GOMAXPROCS %d", blockSize, runtime.GOMAXPROCS(0))
+	}
 	if envBlockSize := os.Getenv("CL_RESERVE_PORTS"); envBlockSize != "" {
 		if parsed, err := strconv.Atoi(envBlockSize); err == nil && parsed > 0 {
 			blockSize = parsed
@@ -109,7 +239,157 @@ func initialize() {
 			logf("WARN", "invalid CL_RESERVE_PORTS value %q, using default blockSize %d", envBlockSize, blockSize)
 		}
 	}
+	if envMaxBlock := os.Getenv("CL_FREEPORT_MAX_BLOCK"); envMaxBlock != "" {
+		if parsed, err := strconv.Atoi(envMaxBlock); err == nil && parsed > 0 {
+			if blockSize > parsed {
+				logf("INFO", "clamping blockSize %d to %d from CL_FREEPORT_MAX_BLOCK environment variable", blockSize, parsed)
+				blockSize = parsed
+			}
+		} else {
+			logf("WARN", "invalid CL_FREEPORT_MAX_BLOCK value %q, not clamping blockSize %d", envMaxBlock, blockSize)
+		}
+	}
+
+	if seedSet {
+		seededRand = rand.New(rand.NewSource(seed))
+	} else {
+		seededRand = rand.New(rand.NewSource(time.Now().UnixNano())) // This is compatible with go 1.19 but unnecessary in >= go1.20
+	}
+	foreignRanges = foreignRangesFromEnv()
+	if base, size, ok := blockFromEnv(); ok {
+		blockSize = size
+		firstPort, lockLn = base, lockFixed(base)
+		fixedBlock = true
+	} else {
+		firstPort, lockLn = probeBlock()
+		allocExtraBlocks()
+	}
+
+	initPool()
+	fillPoolLocked()
 
+	startBackground()
+
+	if onInit != nil {
+		onInit(firstPort, blockSize)
+	}
+}
+
+// fillPoolLocked fills the free list with all available ports of the reserved
+// blocks. Ports beyond the warm probe count are added without checking; Take
+// verifies every port before handing it out anyway. It must be called with mu
+// held.
+func fillPoolLocked() {
+	avoid := avoidSetLocked()
+	candidates := blockPortsLocked()
+	eager := eagerProbeCount(len(candidates))
+	avoided, probed, busy := 0, 0, 0
+	disallowed = 0
+	for _, port := range candidates {
+		if _, ok := avoid[port]; ok {
+			avoided++
+			continue
+		}
+		if allowPredicate != nil && !allowPredicate(port) {
+			disallowed++
+			continue
+		}
+		if eager >= 0 && probed >= eager {
+			freePorts.PushBack(port)
+			unverified[port] = struct{}{}
+			continue
+		}
+		probed++
+		if used := isPortInUse(port); !used {
+			freePorts.PushBack(port)
+		} else {
+			busy++
+		}
+	}
+	total = freePorts.Len()
+	if avoided > 0 {
+		logf("INFO", "excluded %d well-known service ports from the port block", avoided)
+		if total == 0 {
+			logf("WARN", "no ports left in the port block after excluding well-known service ports")
+		}
+	}
+	if disallowed > 0 {
+		logf("INFO", "the allow predicate rejected %d of %d ports of the port block", disallowed, len(candidates))
+		if total == 0 {
+			logf("WARN", "no ports left in the port block after applying the allow predicate")
+		}
+	}
+	if allowPartialBlock {
+		if busy > 0 {
+			logf("INFO", "using partial port block: %d of %d ports usable, %d in use", total, blockSize-1, busy)
+		}
+		if total < minUsablePorts {
+			panic(fmt.Sprintf("freeport: only %d usable ports in the port block at %d, need at least %d", total, firstPort, minUsablePorts))
+		}
+	}
+}
+
+// eagerProbeCount returns how many of the n ports of the block initialize
+// verifies right away, or -1 for all of them. On top of SetWarmProbeCount it
+// keeps eager verification within half of the file descriptor limit, so that
+// it cannot run a tight container out of descriptors.
+func eagerProbeCount(n int) int {
+	fds, err := systemLimit()
+	if err != nil || fds <= 0 {
+		return warmProbeCount
+	}
+	return capProbeCount(warmProbeCount, n, fds)
+}
+
+// capProbeCount caps count eager verifications of a block of n ports at half
+// of the file descriptor limit fds.
+func capProbeCount(count, n, fds int) int {
+	safe := fds / 2
+	if count >= 0 && count <= safe || count < 0 && n <= safe {
+		return count
+	}
+	logf("WARN", "verifying only %d ports during initialization to stay within half of the file descriptor limit %d; the rest are verified when taken", safe, fds)
+	return safe
+}
+
+// initPool creates the empty bookkeeping structures of the pool.
+func initPool() {
+	freePorts = list.New()
+	pendingPorts = list.New()
+	waiters = list.New()
+
+	portLastUser = make(map[int]string)
+	leases = make(map[*Lease]struct{})
+	leased = make(map[int]*Lease)
+	softReservations = list.New()
+	softHeld = make(map[int]*SoftReservation)
+	lostPorts = make(map[int]struct{})
+	holds = make(map[int]net.Listener)
+	taken = make(map[int]struct{})
+	takenAt = make(map[int]time.Time)
+	pinned = make(map[int]struct{})
+	receipts = make(map[int]string)
+	owners = make(map[int]string)
+	quotaUsed = make(map[string]int)
+	unverified = make(map[int]struct{})
+	theftCounts = make(map[int]int)
+	blacklist = make(map[int]struct{})
+}
+
+// startBackground starts the goroutine that re-verifies returned ports.
+func startBackground() {
+	stopWg.Add(1)
+	stopCh = make(chan struct{})
+
+	// Note: we pass this param explicitly to the goroutine so that we can
+	// freely recreate the underlying stop channel during reset() after closing
+	// the original.
+	go checkFreedPorts(stopCh)
+}
+
+// probeBlock sizes the port block to fit the system limits and the ephemeral
+// port range and then reserves one of the candidate blocks.
+func probeBlock() (int, net.Listener) {
 	limit, err := systemLimit()
 	if err != nil {
 		panic("freeport: error getting system limit: " + err.Error())
@@ -123,36 +403,87 @@ func initialize() {
 	if err != nil {
 		panic("freeport: ephemeral port range detection failed: " + err.Error())
 	}
-	if effectiveMaxBlocks < 0 {
-		panic("freeport: no blocks of ports available outside of ephemeral range")
+	if effectiveMaxBlocks <= 0 {
+		panic(fmt.Sprintf("freeport: no block of %d ports fits between minimum port %d and the ephemeral port range [%d, %d]", blockSize, configuredFloor(), ephemeralPortMin, ephemeralPortMax))
 	}
-	if lowPort+effectiveMaxBlocks*blockSize > 65535 {
+	if blockBase(0)+blockSize-1 > portCeiling() {
+		if floor := configuredFloor(); floor != portFloor() {
+			panic(fmt.Sprintf("freeport: minimum port %d is in the ephemeral port range [%d, %d] and no block of %d ports fits above it", floor, ephemeralPortMin, ephemeralPortMax, blockSize))
+		}
+		panic(fmt.Sprintf("freeport: minimum port %d leaves no room for a block of %d ports", portFloor(), blockSize))
+	}
+	// A raised floor or a lowered ceiling leaves room for fewer blocks.
+	for (portFloor() > lowPort || portCeiling() < 65535) && effectiveMaxBlocks > 1 && blockBase(effectiveMaxBlocks-1)+blockSize-1 > portCeiling() {
+		effectiveMaxBlocks--
+	}
+	if blockBase(effectiveMaxBlocks-1)+blockSize-1 > portCeiling() {
 		panic("freeport: block size too big or too many blocks requested")
 	}
 
-	seededRand = rand.New(rand.NewSource(time.Now().UnixNano())) // This is compatible with go 1.19 but unnecessary in >= go1.20
-	firstPort, lockLn = alloc()
+	return alloc()
+}
 
-	condNotEmpty = sync.NewCond(&mu)
-	freePorts = list.New()
-	pendingPorts = list.New()
+// blockFromEnv returns the port block configured via the CL_FREEPORT_RANGE
+// environment variable, e.g. "30000-30255". The first port of the range is
+// used as the system-wide lock for the block like any probed block.
+func blockFromEnv() (base, size int, ok bool) {
+	envRange := os.Getenv("CL_FREEPORT_RANGE")
+	if envRange == "" {
+		return 0, 0, false
+	}
 
-	// fill with all available free ports
-	for port := firstPort + 1; port < firstPort+blockSize; port++ {
-		if used := isPortInUse(port); !used {
-			freePorts.PushBack(port)
-		}
+	min, max, err := parseRange(envRange)
+	if err != nil || max-min < 1 {
+		logf("WARN", "invalid CL_FREEPORT_RANGE value %q, probing for a port block instead", envRange)
+		return 0, 0, false
 	}
-	total = freePorts.Len()
+	if min < minPort {
+		logf("WARN", "CL_FREEPORT_RANGE [%d, %d] starts below the minimum port %d, probing for a port block instead", min, max, minPort)
+		return 0, 0, false
+	}
+	if lo, hi := portClassBounds(); min < lo || max > hi {
+		logf("WARN", "CL_FREEPORT_RANGE [%d, %d] is outside the port class range [%d, %d], probing for a port block instead", min, max, lo, hi)
+		return 0, 0, false
+	}
+	logf("INFO", "using port block [%d, %d] from CL_FREEPORT_RANGE environment variable", min, max)
 
-	stopWg.Add(1)
-	stopCh = make(chan struct{})
+	ephemeralPortMin, ephemeralPortMax, err := getEphemeralPortRange()
+	if err != nil {
+		logf("WARN", "ephemeral port range detection failed: %v", err)
+	} else if ephemeralPortMin > 0 && ephemeralPortMax > 0 && intervalOverlap(min, max, ephemeralPortMin, ephemeralPortMax) {
+		logf("WARN", "CL_FREEPORT_RANGE [%d, %d] overlaps the ephemeral port range [%d, %d]", min, max, ephemeralPortMin, ephemeralPortMax)
+	}
+	if overlapsForeignRange(min, max-min+1) {
+		logf("WARN", "CL_FREEPORT_RANGE [%d, %d] overlaps a range listed in FREEPORT_RESERVED_RANGES", min, max)
+	}
 
-	portLastUser = make(map[int]string)
-	// Note: we pass this param explicitly to the goroutine so that we can
-	// freely recreate the underlying stop channel during reset() after closing
-	// the original.
-	go checkFreedPorts(stopCh)
+	return min, max - min + 1, true
+}
+
+// parseRange parses a doubly-inclusive port range of the form "min-max".
+func parseRange(s string) (min, max int, err error) {
+	lo, hi, found := strings.Cut(strings.TrimSpace(s), "-")
+	if !found {
+		return 0, 0, fmt.Errorf("freeport: invalid port range %q", s)
+	}
+	min, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	max, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if err1 != nil || err2 != nil || min <= 0 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("freeport: invalid port range %q", s)
+	}
+	return min, max, nil
+}
+
+// lockFixed takes the system-wide lock for a block whose placement was
+// chosen externally. Failing to take the lock is logged but not fatal since
+// the range was assigned to this process on purpose.
+func lockFixed(base int) net.Listener {
+	ln, err := listenTCP("tcp", tcpAddr("127.0.0.1", base))
+	if err != nil {
+		logf("WARN", "unable to lock port block at %d: %v", base, err)
+		return nil
+	}
+	return ln
 }
 
 func shutdownGoroutine() {
@@ -177,33 +508,137 @@ func reset() {
 	mu.Lock()
 	defer mu.Unlock()
 
+	teardownLocked()
+	resetCounters()
+}
+
+// teardownLocked reverses initialize() so that the next call to once.Do
+// initializes again. The background goroutine must already be stopped.
+func teardownLocked() {
 	effectiveMaxBlocks = 0
+	ephemeralPortMin, ephemeralPortMax = 0, 0
 	firstPort = 0
-	if lockLn != nil {
-		lockLn.Close()
-		lockLn = nil
-	}
+	releaseListenersLocked()
+	extraBlocks = nil
+	abandonedBlocks = nil
+	fixedBlock = false
+	disallowed = 0
 
 	once = sync.Once{}
 
 	freePorts = nil
 	pendingPorts = nil
+	waiters = nil
 	portLastUser = nil
+	leases = nil
+	leased = nil
+	softReservations = nil
+	softHeld = nil
+	lostPorts = nil
+	holds = nil
+	taken = nil
+	takenAt = nil
+	pinned = nil
+	receipts = nil
+	owners = nil
+	quotaUsed = nil
+	unverified = nil
+	theftCounts = nil
+	blacklist = nil
+	initEnv = nil
+	foreignRanges = nil
+	resetBreakerLocked()
+	verificationPaused = false
 	total = 0
+	maxUsed = 0
+}
+
+// readEnv returns the current values of envVars.
+func readEnv() map[string]string {
+	env := make(map[string]string, len(envVars))
+	for _, name := range envVars {
+		env[name] = os.Getenv(name)
+	}
+	return env
+}
+
+// warnEnvChangedLocked logs a warning, once, if the configuration environment
+// variables changed after initialize read them.
+func warnEnvChangedLocked() {
+	if envChangeWarned || initEnv == nil {
+		return
+	}
+	for name, old := range initEnv {
+		if now := os.Getenv(name); now != old {
+			logf("WARN", "%s changed from %q to %q after freeport was initialized; the change is ignored until ReinitializeFromEnv is called", name, old, now)
+			envChangeWarned = true
+		}
+	}
+}
+
+// outstandingLocked returns the number of ports of the block that are held
+// outside of the pool: taken, pinned or being verified. The block must not be
+// replaced while there are any. It must be called with mu held.
+func outstandingLocked() int {
+	return len(taken) + len(pinned) + verifying
+}
+
+// ReinitializeFromEnv releases the port block and reserves a new one using
+// the current values of the CL_RESERVE_PORTS, CL_FREEPORT_MAX_BLOCK and
+// CL_FREEPORT_RANGE environment variables. It returns an error if any ports
+// are still taken. If freeport has not been initialized yet,
+// ReinitializeFromEnv does nothing since the next Take reads the environment
+// anyway.
+func ReinitializeFromEnv() error {
+	mu.Lock()
+	if freePorts == nil {
+		mu.Unlock()
+		return nil
+	}
+	if n := outstandingLocked(); n > 0 {
+		mu.Unlock()
+		return fmt.Errorf("freeport: cannot reinitialize with %d ports still taken", n)
+	}
+	mu.Unlock()
+
+	shutdownGoroutine()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Ports may have been taken while the background goroutine was stopping.
+	if n := outstandingLocked(); n > 0 {
+		startBackground()
+		return fmt.Errorf("freeport: cannot reinitialize with %d ports still taken", n)
+	}
+
+	logf("INFO", "reinitializing the port block from the environment")
+	// Wake anyone blocked in Take so they re-check against the new pool.
+	wakeAllLocked()
+	teardownLocked()
+	once.Do(initialize)
+	return nil
 }
 
 func checkFreedPorts(stopCh <-chan struct{}) {
 	defer stopWg.Done()
 
-	ticker := time.NewTicker(250 * time.Millisecond)
+	timer := time.NewTimer(currentReverifyInterval())
+	defer timer.Stop()
 	for {
 		select {
 		case <-stopCh:
 			logf("INFO", "Closing checkFreedPorts()")
 			return
-		case <-ticker.C:
+		case <-reverifyIntervalChanged:
+			timer.Stop()
+		case <-timer.C:
 			checkFreedPortsOnce()
+			expireLeases()
+			reclaimSoft()
+			reclaimLostPorts()
 		}
+		timer.Reset(currentReverifyInterval())
 	}
 }
 
@@ -211,39 +646,98 @@ func checkFreedPortsOnce() {
 	mu.Lock()
 	defer mu.Unlock()
 
+	if verificationPaused {
+		return
+	}
+	verifyPendingLocked(false)
+}
+
+// verifyPendingLocked moves the pending ports that are free again to the free
+// list and wakes the waiters they satisfy. It must be called with mu held.
+// With SetDialConfirm, mu is released while the ports that pass the bind
+// check are dialed, so that slow dials don't hold up Take and Return; the
+// ports are only moved if they are still pending afterwards, and not at all
+// if verification has been paused in the meantime, unless ignorePause is set.
+func verifyPendingLocked(ignorePause bool) {
 	pending := pendingPorts.Len()
-	remove := make([]*list.Element, 0, pending)
+	free := make([]int, 0, pending)
 	for elem := pendingPorts.Front(); elem != nil; elem = elem.Next() {
 		port := elem.Value.(int)
-		if used := isPortInUse(port); !used {
-			freePorts.PushBack(port)
-			remove = append(remove, elem)
-		} else {
-			logf("WARN", "port %d still being used by %q", port, portLastUser[port])
+		if isPortInUse(port) {
+			logf("WARN", "port %v still being used by %q", logPort(port), portLastUser[port])
+			continue
 		}
+		free = append(free, port)
 	}
 
-	retained := pending - len(remove)
+	if dialConfirm && len(free) > 0 {
+		before := pendingPorts
+		free = dialRefusedUnlocked(free)
+		if pendingPorts != before || verificationPaused && !ignorePause {
+			return
+		}
+	}
 
-	if retained > 0 {
+	moved := 0
+	for _, port := range free {
+		if removePort(pendingPorts, port) {
+			freePorts.PushBack(port)
+			moved++
+		}
+	}
+
+	if retained := pending - moved; retained > 0 {
 		logf("WARN", "%d out of %d pending ports are still in use; something probably didn't wait around for the port to be closed!", retained, pending)
 	}
 
-	if len(remove) == 0 {
+	if moved == 0 {
 		return
 	}
+	wakeSatisfiableLocked()
+}
 
-	for _, elem := range remove {
-		pendingPorts.Remove(elem)
+// dialRefusedUnlocked releases mu, dials each of ports and returns those for
+// which dialRefused holds, with mu held again.
+func dialRefusedUnlocked(ports []int) []int {
+	mu.Unlock()
+	defer mu.Lock()
+
+	refused := ports[:0]
+	for _, port := range ports {
+		if dialRefused(port) {
+			refused = append(refused, port)
+		} else {
+			logf("WARN", "port %v still accepts connections", logPort(port))
+		}
 	}
+	return refused
+}
 
-	condNotEmpty.Broadcast()
+// PauseVerification stops the background goroutine from re-verifying returned
+// ports until ResumeVerification is called, e.g. to keep it from contending
+// for locks during a benchmark. Returned ports stay pending while verification
+// is paused. A verification pass that is already running completes before
+// PauseVerification returns.
+func PauseVerification() {
+	mu.Lock()
+	defer mu.Unlock()
+	verificationPaused = true
+}
+
+// ResumeVerification undoes PauseVerification. Ports returned while
+// verification was paused are processed on the next pass of the background
+// goroutine.
+func ResumeVerification() {
+	mu.Lock()
+	defer mu.Unlock()
+	verificationPaused = false
 }
 
 // adjustMaxBlocks avoids having the allocation ranges overlap the ephemeral
 // port range.
 func adjustMaxBlocks() (int, error) {
-	ephemeralPortMin, ephemeralPortMax, err := getEphemeralPortRange()
+	var err error
+	ephemeralPortMin, ephemeralPortMax, err = getEphemeralPortRange()
 	if err != nil {
 		return 0, err
 	}
@@ -254,8 +748,12 @@ func adjustMaxBlocks() (int, error) {
 	}
 
 	logf("INFO", "detected ephemeral port range of [%d, %d]", ephemeralPortMin, ephemeralPortMax)
+	if !avoidEphemeral() {
+		logf("WARN", "no room for a block of %d ports in the dynamic port range outside the ephemeral port range; placing the block inside the ephemeral range", blockSize)
+		return maxBlocks, nil
+	}
 	for block := 0; block < maxBlocks; block++ {
-		min := lowPort + block*blockSize
+		min := blockBase(block)
 		max := min + blockSize
 		overlap := intervalOverlap(min, max-1, ephemeralPortMin, ephemeralPortMax)
 		if overlap {
@@ -266,17 +764,87 @@ func adjustMaxBlocks() (int, error) {
 	return maxBlocks, nil
 }
 
+// CheckEphemeralOverlap re-reads the current ephemeral port range and reports
+// whether any of the reserved port blocks now overlaps it, together with a
+// human-readable description of the ranges involved. The ephemeral range can
+// be retuned at runtime, so long-running processes may want to call this
+// periodically. It reports false if freeport has not been initialized yet.
+func CheckEphemeralOverlap() (bool, string) {
+	mu.Lock()
+	bases, size := append([]int{firstPort}, extraBlocks...), blockSize
+	mu.Unlock()
+
+	if bases[0] == 0 {
+		return false, "freeport: not initialized"
+	}
+
+	ephemeralPortMin, ephemeralPortMax, err := getEphemeralPortRange()
+	if err != nil {
+		return false, "freeport: ephemeral port range detection failed: " + err.Error()
+	}
+	if ephemeralPortMin <= 0 || ephemeralPortMax <= 0 {
+		return false, fmt.Sprintf("freeport: ephemeral port range detection not configured for GOOS=%q", runtime.GOOS)
+	}
+
+	ranges := make([]string, 0, len(bases))
+	for _, base := range bases {
+		if intervalOverlap(base, base+size-1, ephemeralPortMin, ephemeralPortMax) {
+			return true, fmt.Sprintf("freeport: port block [%d, %d] overlaps ephemeral port range [%d, %d]", base, base+size-1, ephemeralPortMin, ephemeralPortMax)
+		}
+		ranges = append(ranges, fmt.Sprintf("[%d, %d]", base, base+size-1))
+	}
+	if len(ranges) == 1 {
+		return false, fmt.Sprintf("freeport: port block %s does not overlap ephemeral port range [%d, %d]", ranges[0], ephemeralPortMin, ephemeralPortMax)
+	}
+	return false, fmt.Sprintf("freeport: port blocks %s do not overlap ephemeral port range [%d, %d]", strings.Join(ranges, ", "), ephemeralPortMin, ephemeralPortMax)
+}
+
+// The IANA dynamic port range, which EphemeralRange reports when the OS range
+// cannot be detected.
+const (
+	defaultEphemeralPortMin = 49152
+	defaultEphemeralPortMax = 65535
+)
+
+// EphemeralRange returns the bounds of the OS ephemeral port range that
+// freeport keeps its port block clear of. If the range was not determined
+// during initialization it is detected now. If it cannot be detected on this
+// platform, or detection fails, the IANA dynamic range is returned and guessed
+// is true; note that freeport does not avoid a guessed range.
+func EphemeralRange() (min, max int, guessed bool) {
+	mu.Lock()
+	min, max = ephemeralPortMin, ephemeralPortMax
+	mu.Unlock()
+	if min > 0 && max > 0 {
+		return min, max, false
+	}
+
+	min, max, err := getEphemeralPortRange()
+	if err != nil || min <= 0 || max <= 0 {
+		return defaultEphemeralPortMin, defaultEphemeralPortMax, true
+	}
+	return min, max, false
+}
+
 // alloc reserves a port block for exclusive use for the lifetime of the
 // application. lockLn serves as a system-wide mutex for the port block and is
 // implemented as a TCP listener which is bound to the firstPort and which will
 // be automatically released when the application terminates.
 func alloc() (int, net.Listener) {
+	if probeStrategy == ProbeSequential {
+		return allocSequential()
+	}
+
+	var tried []int
 	start := int(seededRand.Int31n(int32(effectiveMaxBlocks)))
 	for i := 0; i < effectiveMaxBlocks; i++ {
 		block := (start + i) % effectiveMaxBlocks
-		firstPort := lowPort + block*blockSize
-		ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", firstPort))
-		if err != nil {
+		firstPort := blockBase(block)
+		if overlapsForeignRange(firstPort, blockSize) || !blockAllowed(firstPort) || abandonedLocked(firstPort) {
+			continue
+		}
+		ln := tryBlock(firstPort, &tried)
+		if ln == nil {
 			continue
 		}
 		// logf("DEBUG", "allocated port block %d (%d-%d)", block, firstPort, firstPort+blockSize-1)
@@ -285,6 +853,207 @@ func alloc() (int, net.Listener) {
 	panic("freeport: cannot allocate port block")
 }
 
+// blockBase returns the first port of the given candidate block. Candidate
+// blocks start at portFloor and are laid out back to back, with each base
+// rounded up to a multiple of blockAlignment.
+func blockBase(block int) int {
+	align := blockAlignment
+	if align < 1 {
+		align = 1
+	}
+	roundUp := func(n int) int { return (n + align - 1) / align * align }
+	return roundUp(portFloor()) + block*roundUp(blockSize)
+}
+
+// portFloor returns the lowest port that may be part of the block. If no block
+// fits between the configured floor and the ephemeral port range, the blocks
+// start above the ephemeral range instead.
+func portFloor() int {
+	floor := configuredFloor()
+	if avoidEphemeral() && ephemeralPortMin > 0 && ephemeralPortMax > 0 &&
+		intervalOverlap(floor, floor+blockSize-1, ephemeralPortMin, ephemeralPortMax) {
+		return ephemeralPortMax + 1
+	}
+	return floor
+}
+
+// configuredFloor returns the lowest port allowed by lowPort, SetMinPort and
+// SetPortClass.
+func configuredFloor() int {
+	floor := lowPort
+	if minPort > floor {
+		floor = minPort
+	}
+	if lo, _ := portClassBounds(); lo > floor {
+		floor = lo
+	}
+	return floor
+}
+
+// portCeiling returns the highest port that may be part of the block.
+func portCeiling() int {
+	_, hi := portClassBounds()
+	return hi
+}
+
+// portClassBounds returns the range the block is placed in for the port
+// class set with SetPortClass. For PortClassDynamic the range starts above
+// the ephemeral port range if a block fits there.
+func portClassBounds() (lo, hi int) {
+	switch portClass {
+	case PortClassDynamic:
+		lo, _ = dynamicFloor()
+		return lo, 65535
+	case PortClassRegistered:
+		return 1024, defaultEphemeralPortMin - 1
+	default:
+		return 0, 65535
+	}
+}
+
+// dynamicFloor returns the lowest port of a PortClassDynamic block, which is
+// above the ephemeral port range if it overlaps the start of the dynamic range
+// and a block fits above it. inEphemeral is set if the block cannot avoid the
+// ephemeral range.
+func dynamicFloor() (floor int, inEphemeral bool) {
+	floor = defaultEphemeralPortMin
+	if ephemeralPortMin <= 0 || ephemeralPortMax <= 0 ||
+		!intervalOverlap(floor, floor+blockSize-1, ephemeralPortMin, ephemeralPortMax) {
+		return floor, false
+	}
+	if above := ephemeralPortMax + 1; above+blockSize-1 <= 65535 {
+		return above, false
+	}
+	return floor, true
+}
+
+// avoidEphemeral reports whether candidate blocks overlapping the ephemeral
+// port range are skipped, which is always the case unless PortClassDynamic
+// leaves no room outside of it.
+func avoidEphemeral() bool {
+	if portClass != PortClassDynamic {
+		return true
+	}
+	_, inEphemeral := dynamicFloor()
+	return !inEphemeral
+}
+
+// allocSequential is like alloc but tries the blocks in order starting from
+// lowPort. Blocks overlapping the ephemeral port range are skipped rather than
+// ending the search, so blocks above the ephemeral range are candidates too.
+func allocSequential() (int, net.Listener) {
+	var tried []int
+	for block := 0; blockBase(block)+blockSize-1 <= portCeiling(); block++ {
+		firstPort := blockBase(block)
+		if avoidEphemeral() && ephemeralPortMin > 0 && ephemeralPortMax > 0 &&
+			intervalOverlap(firstPort, firstPort+blockSize-1, ephemeralPortMin, ephemeralPortMax) {
+			continue
+		}
+		if overlapsForeignRange(firstPort, blockSize) || !blockAllowed(firstPort) || abandonedLocked(firstPort) {
+			continue
+		}
+		ln := tryBlock(firstPort, &tried)
+		if ln == nil {
+			continue
+		}
+		return firstPort, ln
+	}
+	panic("freeport: cannot allocate port block")
+}
+
+// allocExtraBlocks reserves the blocks requested with SetBlockCount beyond the
+// first one. Each block is probed like the first; the blocks already held by
+// this process are skipped since their locks cannot be taken again.
+func allocExtraBlocks() {
+	if blockCount <= 1 {
+		return
+	}
+	if probeStrategy != ProbeSequential && blockCount > effectiveMaxBlocks {
+		panic(fmt.Sprintf("freeport: cannot fit %d port blocks of %d ports in the usable port space, only %d fit", blockCount, blockSize, effectiveMaxBlocks))
+	}
+	for len(extraBlocks) < blockCount-1 {
+		base, ln := alloc()
+		extraBlocks = append(extraBlocks, base)
+		extraLocks = append(extraLocks, ln)
+	}
+	logf("INFO", "reserved %d port blocks of %d ports", blockCount, blockSize)
+}
+
+// blockAllowed reports whether the allow predicate set with SetAllowPredicate
+// accepts at least one port of the block starting at base.
+func blockAllowed(base int) bool {
+	if allowPredicate == nil {
+		return true
+	}
+	for port := base + 1; port < base+blockSize; port++ {
+		if allowPredicate(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockPortsLocked returns the usable ports of all reserved blocks, that is
+// all but the first port of each, which is taken by the lock. It must be
+// called with mu held.
+func blockPortsLocked() []int {
+	bases := append([]int{firstPort}, extraBlocks...)
+	ports := make([]int, 0, len(bases)*(blockSize-1))
+	for _, base := range bases {
+		for port := base + 1; port < base+blockSize; port++ {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// inBlockLocked reports whether port is a usable port of one of the reserved
+// blocks. It must be called with mu held.
+func inBlockLocked(port int) bool {
+	if port > firstPort && port < firstPort+blockSize {
+		return true
+	}
+	for _, base := range extraBlocks {
+		if port > base && port < base+blockSize {
+			return true
+		}
+	}
+	return false
+}
+
+// usableRangesLocked describes the usable ports of the reserved blocks for
+// error messages. It must be called with mu held.
+func usableRangesLocked() string {
+	if len(extraBlocks) == 0 {
+		return fmt.Sprintf("port block [%d, %d]", firstPort+1, firstPort+blockSize-1)
+	}
+	ranges := make([]string, 0, 1+len(extraBlocks))
+	for _, base := range append([]int{firstPort}, extraBlocks...) {
+		ranges = append(ranges, fmt.Sprintf("[%d, %d]", base+1, base+blockSize-1))
+	}
+	return "port blocks " + strings.Join(ranges, ", ")
+}
+
+// tryBlock tries to take the system-wide lock for the block starting at base
+// and records the attempt in tried. It returns nil if the block is held by
+// someone else, and panics once SetMaxProbeAttempts blocks have been tried.
+func tryBlock(base int, tried *[]int) net.Listener {
+	if maxProbeAttempts > 0 && len(*tried) >= maxProbeAttempts {
+		ranges := make([]string, len(*tried))
+		for i, b := range *tried {
+			ranges[i] = fmt.Sprintf("[%d, %d]", b, b+blockSize-1)
+		}
+		panic(fmt.Sprintf("freeport: cannot allocate port block after %d attempts; tried %s", len(*tried), strings.Join(ranges, ", ")))
+	}
+	*tried = append(*tried, base)
+
+	ln, err := listenTCP("tcp", tcpAddr("127.0.0.1", base))
+	if err != nil {
+		return nil
+	}
+	return ln
+}
+
 // MustTake is the same as Take except it panics on error.
 //
 // Deprecated: Use GetN or GetOne instead.
@@ -304,47 +1073,338 @@ func MustTake(n int) (ports []int) {
 // Most callers should prefer GetN or GetOne.
 func Take(n int) (ports []int, err error) {
 	if n <= 0 {
-		return nil, fmt.Errorf("freeport: cannot take %d ports", n)
+		return nil, invalidCountError(n)
 	}
 
+	span := startSpan("freeport.Take")
+	defer span.End()
+
+	defer runHooks()
 	mu.Lock()
 	defer mu.Unlock()
 
 	// Reserve a port block
 	once.Do(initialize)
 
-	if n > total {
-		return nil, fmt.Errorf("freeport: block size too small")
+	ports, waited, err := takeProgressLocked(n, reserveFree, nil, nil)
+	span.SetAttribute("freeport.requested", int64(n))
+	span.SetAttribute("freeport.wait_ns", int64(waited))
+	if err == nil {
+		countTake(ports)
+	}
+	return ports, err
+}
+
+// takeLocked implements Take. It must be called with mu held and after the
+// package has been initialized.
+func takeLocked(n int) (ports []int, err error) {
+	ports, _, err = takeProgressLocked(n, reserveFree, nil, nil)
+	return ports, err
+}
+
+// takeProgressLocked implements TakeWithProgress and reports how long it
+// spent blocked waiting for ports to be returned. It leaves at least reserve
+// ports on the free list, waiting for more ports to be returned if necessary.
+// Candidates that fail verification are reported to reject, if it is not nil.
+// It must be called with mu held and after the package has been initialized.
+// mu is released while progress is called.
+func takeProgressLocked(n, reserve int, progress func(pos int), reject func(port int, err error)) (ports []int, waited time.Duration, err error) {
+	warnEnvChangedLocked()
+
+	if n > total-reserve {
+		return nil, 0, tooSmallErrorLocked(n, reserve)
+	}
+
+	var waiter *list.Element
+	enqueue := func() {
+		waiter = waiters.PushBack(newPendingTake(n, reserve, progress != nil))
+	}
+	defer func() {
+		if waiter != nil {
+			waiters.Remove(waiter)
+			// let the waiters behind us observe their new position
+			wakeDequeuedLocked()
+		}
+	}()
+
+	if fifoTakes {
+		enqueue()
 	}
 
+	// putBack undoes the ports taken so far before an error is returned,
+	// restoring their order at the front of the free list.
+	putBack := func() {
+		for i := len(ports) - 1; i >= 0; i-- {
+			putBackLocked(ports[i])
+		}
+	}
+
+	lastPos := -1
+	blocked := false
 	for len(ports) < n {
-		for freePorts.Len() == 0 {
+		if err := breakerErrorLocked(); err != nil {
+			putBack()
+			return nil, waited, err
+		}
+		for freePorts.Len() <= reserve || (fifoTakes && waiters.Front() != waiter) {
 			if total == 0 {
-				return nil, fmt.Errorf("freeport: impossible to satisfy request; there are no actual free ports in the block anymore")
+				if !canReinitLocked() {
+					return nil, waited, fmt.Errorf("%w: cannot take %d ports; there are no actual free ports in the block anymore", ErrExhausted, n)
+				}
+				if err := reinitOnEmptyLocked(n); err != nil {
+					return nil, waited, err
+				}
+				if n > total-reserve {
+					return nil, waited, tooSmallErrorLocked(n, reserve)
+				}
+				continue
 			}
-			// if this warning starts to come up too often, consider dynamic allocation of another block
-			logf("WARN", "waiting for free ports to be available")
-			condNotEmpty.Wait()
+			if total <= reserve {
+				putBack()
+				return nil, waited, fmt.Errorf("%w: cannot take %d ports; only reserved ports are left in the block (total=%d, reserve=%d)", ErrExhausted, n, total, reserve)
+			}
+			if !blocked {
+				blocked = true
+				if others := waiters.Len() - queued(waiter); maxWaiters > 0 && others >= maxWaiters {
+					putBack()
+					return nil, waited, fmt.Errorf("%w: %d callers are already waiting for ports", ErrTooManyWaiters, others)
+				}
+			}
+			if waiter == nil {
+				enqueue()
+			}
+			// ask soft holders for the ports that are missing
+			revokeSoftLocked(n - len(ports) - (freePorts.Len() - reserve))
+			if pos := queuePosition(waiter); progress != nil && pos != lastPos {
+				lastPos = pos
+				mu.Unlock()
+				progress(pos)
+				mu.Lock()
+				continue
+			}
+			if freePorts.Len() <= reserve {
+				// if this warning starts to come up too often, consider dynamic allocation of another block
+				logf("WARN", "waiting for free ports to be available")
+			}
+			start := time.Now()
+			waiter.Value.(*pendingTake).wait(n - len(ports))
+			waited += time.Since(start)
 		}
 
 		elem := freePorts.Front()
 		freePorts.Remove(elem)
 		port := elem.Value.(int)
 
-		if used := isPortInUse(port); used {
+		if err := verifyLocked(port); err != nil {
 			// Something outside of the test suite has stolen this port, possibly
 			// due to assignment to an ephemeral port, remove it completely.
-			logf("WARN", "leaked port %d due to theft; removing from circulation", port)
-			total--
+			stolenLocked(port)
+			if reject != nil {
+				reject(port, err)
+			}
 			continue
 		}
 
+		handOutLocked(port)
 		ports = append(ports, port)
 	}
 
+	updateMaxUsedLocked()
+	sortResultsLocked(ports)
+	return ports, waited, nil
+}
+
+// queued returns 1 if waiter is in the wait queue and 0 otherwise.
+func queued(waiter *list.Element) int {
+	if waiter == nil {
+		return 0
+	}
+	return 1
+}
+
+// TakeReserved is like Take but may also use the ports kept in reserve with
+// SetReserveFree, for high-priority callers that must not be starved by
+// ordinary takes. Once the reserve is exhausted too, it waits for ports to be
+// returned like Take.
+func TakeReserved(n int) (ports []int, err error) {
+	if n <= 0 {
+		return nil, invalidCountError(n)
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	ports, _, err = takeProgressLocked(n, 0, nil, nil)
+	if err == nil {
+		countTake(ports)
+	}
+	return ports, err
+}
+
+// queuePosition returns the number of waiters ahead of waiter. It must be
+// called with mu held.
+func queuePosition(waiter *list.Element) int {
+	pos := 0
+	for elem := waiters.Front(); elem != nil && elem != waiter; elem = elem.Next() {
+		pos++
+	}
+	return pos
+}
+
+// TakeWithProgress is like Take but, while it is blocked waiting for ports to
+// be returned, calls progress with the number of other callers waiting ahead
+// of it whenever that number changes. progress is called without any internal
+// locks held.
+func TakeWithProgress(n int, progress func(pos int)) (ports []int, err error) {
+	if n <= 0 {
+		return nil, invalidCountError(n)
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	ports, _, err = takeProgressLocked(n, reserveFree, progress, nil)
+	if err == nil {
+		countTake(ports)
+	}
+	return ports, err
+}
+
+// TakeAll takes every port that is free right now, except for the ports kept
+// in reserve with SetReserveFree, in one atomic step. Unlike Take(Available())
+// it cannot race with other callers between counting and taking. Ports found
+// to be stolen are skipped. If no ports are free it returns an empty slice and
+// no error; it never waits for ports to be returned.
+func TakeAll() ([]int, error) {
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	ports := make([]int, 0, freePorts.Len())
+	for freePorts.Len() > reserveFree {
+		elem := freePorts.Front()
+		freePorts.Remove(elem)
+		port := elem.Value.(int)
+
+		if err := verifyLocked(port); err != nil {
+			stolenLocked(port)
+			continue
+		}
+		handOutLocked(port)
+		ports = append(ports, port)
+	}
+	if len(ports) == 0 {
+		return ports, nil
+	}
+
+	updateMaxUsedLocked()
+	sortResultsLocked(ports)
+	countTake(ports)
 	return ports, nil
 }
 
+// takePortLocked removes a specific port from the free list if it is present
+// and not in use, reporting whether it was taken. A port that is found to be in
+// use is removed from circulation the same way Take handles theft. It must be
+// called with mu held and after the package has been initialized.
+func takePortLocked(port int) bool {
+	for elem := freePorts.Front(); elem != nil; elem = elem.Next() {
+		if elem.Value.(int) != port {
+			continue
+		}
+		freePorts.Remove(elem)
+		if err := verifyLocked(port); err != nil {
+			stolenLocked(port)
+			return false
+		}
+		handOutLocked(port)
+		updateMaxUsedLocked()
+		return true
+	}
+	return false
+}
+
+// handOutLocked records that port, which has just been removed from the free
+// list, is now held by a caller. It must be called with mu held.
+func handOutLocked(port int) {
+	taken[port] = struct{}{}
+	takenAt[port] = time.Now()
+	delete(unverified, port)
+	// The previous user's label no longer applies.
+	delete(portLastUser, port)
+	holdLocked(port)
+}
+
+// removePort removes port from l, which must be freePorts or pendingPorts,
+// and reports whether it was there. It must be called with mu held.
+func removePort(l *list.List, port int) bool {
+	for elem := l.Front(); elem != nil; elem = elem.Next() {
+		if elem.Value.(int) == port {
+			l.Remove(elem)
+			return true
+		}
+	}
+	return false
+}
+
+// putBackLocked undoes handOutLocked for a port that turned out not to be
+// needed, placing it back at the front of the free list without
+// re-verification. It must be called with mu held.
+func putBackLocked(port int) {
+	releaseHoldLocked(port)
+	delete(taken, port)
+	delete(takenAt, port)
+	freePorts.PushFront(port)
+}
+
+// stolenLocked removes a port that was found to be in use while on the free
+// list from circulation. It must be called with mu held.
+func stolenLocked(port int) {
+	logf("WARN", "leaked port %v due to theft; removing from circulation", logPort(port))
+	total--
+	delete(unverified, port)
+	lostPorts[port] = struct{}{}
+	thefts.Add(1)
+	theftCounts[port]++
+	blacklistIfChronicLocked(port)
+}
+
+// updateMaxUsedLocked records the current number of taken ports in maxUsed if
+// it is a new high-water mark. It must be called with mu held.
+func updateMaxUsedLocked() {
+	if used := total - freePorts.Len() - pendingPorts.Len(); used > maxUsed {
+		maxUsed = used
+	}
+}
+
+// Initialized reports whether freeport has reserved its port block, either
+// because ports were taken or because of WarmUpContext, ImportState and the
+// like. Unlike those it never triggers initialization. Initialization happens
+// under the same lock, so a concurrent first Take is either fully visible or
+// not at all.
+func Initialized() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return firstPort != 0
+}
+
+// MaxUsed returns the largest number of ports that have been taken at the same
+// time since freeport was initialized. It can be used to check whether the
+// block size is right-sized for a test suite.
+func MaxUsed() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return maxUsed
+}
+
 // peekFree returns the next port that will be returned by Take to aid in testing.
 func peekFree() int {
 	mu.Lock()
@@ -366,6 +1426,36 @@ func peekAllFree() []int {
 	return out
 }
 
+// Available returns the number of ports that Take could hand out right now
+// without waiting, e.g. for a scheduler deciding whether to start another
+// parallel test. Ports that were returned but not verified yet and ports kept
+// in reserve with SetReserveFree are not counted. The count is a consistent
+// snapshot but may be stale by the time the caller acts on it.
+func Available() int {
+	mu.Lock()
+	defer mu.Unlock()
+	once.Do(initialize)
+	if n := freePorts.Len() - reserveFree; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// TakenPorts returns the ports that are currently taken, in ascending order.
+// It is meant for debugging, e.g. to find out what is holding capacity when a
+// Take fails. The returned slice is a copy.
+func TakenPorts() []int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	out := make([]int, 0, len(taken))
+	for port := range taken {
+		out = append(out, port)
+	}
+	sort.Ints(out)
+	return out
+}
+
 // stats returns diagnostic data to aid in testing
 func stats() (numTotal, numPending, numFree int) {
 	mu.Lock()
@@ -374,29 +1464,295 @@ func stats() (numTotal, numPending, numFree int) {
 }
 
 // Return returns a block of ports back to the general pool. These ports should
-// have been returned from a call to Take().
+// have been returned from a call to Take(). Ports that are not currently taken,
+// e.g. because they have already been returned, are ignored.
 func Return(ports []int) {
+	ReturnWithReason(ports, ReasonUnspecified)
+}
+
+// ReturnWithReason is like Return but annotates the return with a reason,
+// e.g. whether the test that used the ports passed or failed. The reason is
+// only used for bookkeeping, see ReturnReasons.
+func ReturnWithReason(ports []int, reason string) {
 	if len(ports) == 0 {
 		return // convenience short circuit for test ergonomics
 	}
 
+	span := startSpan("freeport.Return")
+	defer span.End()
+	span.SetAttribute("freeport.returned", int64(len(ports)))
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	if validateReturns {
+		if err := validateReturnLocked(ports); err != nil {
+			logf("WARN", "ignoring invalid return: %v", err)
+		}
+	}
+	returnLocked(ports, reason)
+}
+
+// ReturnErr is like Return but reports ports that cannot be returned, because
+// they are outside of the reserved port block or are not currently taken, as
+// an error instead of ignoring them. The remaining ports are returned either
+// way.
+func ReturnErr(ports []int) error {
+	if len(ports) == 0 {
+		return nil
+	}
+
+	span := startSpan("freeport.Return")
+	defer span.End()
+	span.SetAttribute("freeport.returned", int64(len(ports)))
+
+	defer runHooks()
 	mu.Lock()
 	defer mu.Unlock()
 
+	err := validateReturnLocked(ports)
+	returnLocked(ports, ReasonUnspecified)
+	return err
+}
+
+// validateReturnLocked checks that all ports can be returned. It must be
+// called with mu held.
+func validateReturnLocked(ports []int) error {
+	var errs []error
 	for _, port := range ports {
-		if port > firstPort && port < firstPort+blockSize {
-			pendingPorts.PushBack(port)
+		if !inBlockLocked(port) {
+			errs = append(errs, fmt.Errorf("freeport: cannot return port %d outside of the %s", port, usableRangesLocked()))
+		} else if _, ok := taken[port]; !ok {
+			errs = append(errs, fmt.Errorf("freeport: cannot return port %d which is not taken", port))
 		}
 	}
+	return errors.Join(errs...)
 }
 
+// returnLocked implements ReturnWithReason. It must be called with mu held.
+func returnLocked(ports []int, reason string) {
+	returned := make([]int, 0, len(ports))
+	var held []time.Duration
+	for _, port := range ports {
+		if _, ok := taken[port]; !ok {
+			// Returning a port that is not taken would put it on the free or
+			// pending list twice and let Take hand it out to two callers.
+			if inBlockLocked(port) {
+				logf("WARN", "ignoring return of port %v which is not taken", logPort(port))
+			}
+			continue
+		}
+		delete(taken, port)
+		if onReturnDuration != nil {
+			held = append(held, time.Since(takenAt[port]))
+		}
+		delete(takenAt, port)
+		delete(receipts, port)
+		delete(leased, port)
+		delete(softHeld, port)
+		if identity, ok := owners[port]; ok {
+			delete(owners, port)
+			releaseQuotaLocked(identity, 1)
+		}
+		releaseHoldLocked(port)
+		pendingPorts.PushBack(port)
+		returned = append(returned, port)
+	}
+	countReturnLocked(len(returned), reason)
+	queueHookLocked(false, returned)
+	queueDurationsLocked(returned, held)
+}
+
+// isPortInUse reports whether port cannot be bound. Unlike Probe it skips the
+// strict, TLS and stability checks, which would wait under mu for every port
+// when filling the pool or re-verifying returned ports; they run when a port
+// is handed out.
 func isPortInUse(port int) bool {
-	ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", port))
+	return probeBind(port, false, nil) != nil
+}
+
+// verifyLocked decides whether a candidate port that has just been removed
+// from the free list may be handed out, using the verifier installed with
+// SetVerifier or the built-in bind check. It returns nil if the port is usable.
+// It must be called with mu held; mu is released while a custom verifier or
+// the strict, TLS and stability checks run.
+func verifyLocked(port int) error {
+	err := func() error {
+		verify := verifier
+		if verify == nil {
+			if !strictVerify.Load() && tlsVerifyConfig.Load() == nil && stabilityCheck.Load() == 0 {
+				return Probe(port)
+			}
+			verify = Probe
+		}
+		// Keep the block from being reinitialized under the port.
+		verifying++
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			verifying--
+		}()
+		return verify(port)
+	}()
+	recordVerifyLocked(err != nil)
+	return err
+}
+
+// Probe checks whether port, which need not come from freeport, is currently
+// free using the same bind freeport uses to verify its own ports. It returns
+// nil if the port is free and the bind error otherwise. The probe listener is
+// closed before Probe returns and the pool is not affected.
+func Probe(port int) error {
+	if err := probeBind(port, strictVerify.Load(), tlsVerifyConfig.Load()); err != nil {
+		return err
+	}
+
+	// A port that something grabs between its own retries is free at the
+	// first bind but not a moment later; bind it again to catch that.
+	if d := time.Duration(stabilityCheck.Load()); d > 0 {
+		time.Sleep(d)
+		ln, err := listenVerify("tcp", tcpAddr("127.0.0.1", port))
+		if err != nil {
+			return fmt.Errorf("freeport: port %d not stably free: %w", port, err)
+		}
+		return ln.Close()
+	}
+	return nil
+}
+
+// probeBind runs the bind checks of Probe, including the strict check if
+// strict is set and the TLS check if tlsCfg is not nil.
+func probeBind(port int, strict bool, tlsCfg *tls.Config) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("freeport: invalid port %d", port)
+	}
+	ln, err := listenVerify("tcp", tcpAddr("127.0.0.1", port))
 	if err != nil {
-		return true
+		return err
 	}
-	ln.Close()
-	return false
+	// On dual-stack hosts an IPv6-only listener, e.g. on [::1], doesn't
+	// block the IPv4 bind above but does make a later bind to the wildcard
+	// address fail, so check the IPv6 side of the port as well.
+	if hasIPv6() {
+		ln6, err := listenVerify("tcp6", tcpAddr("::", port))
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln6.Close()
+	}
+	if strict {
+		if err := expectNoInbound(ln); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+	if tlsCfg != nil {
+		if err := verifyTLS(ln, tlsCfg); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+	return ln.Close()
+}
+
+var (
+	ipv6Once sync.Once
+	ipv6     bool
+)
+
+// hasIPv6 reports whether IPv6 listeners can be opened on this host.
+func hasIPv6() bool {
+	ipv6Once.Do(func() {
+		ln, err := listenTCP("tcp6", tcpAddr("::1", 0))
+		if err != nil {
+			logf("INFO", "IPv6 is not available, verifying ports on IPv4 only: %v", err)
+			return
+		}
+		ln.Close()
+		ipv6 = true
+	})
+	return ipv6
+}
+
+// strictVerifyWindow is how long a strict verification waits for stray
+// inbound connections.
+const strictVerifyWindow = 50 * time.Millisecond
+
+// dialConfirmTimeout is how long dialRefused waits for a connection attempt.
+const dialConfirmTimeout = 50 * time.Millisecond
+
+// dialTimeout dials for dialRefused; tests replace it.
+var dialTimeout = net.DialTimeout
+
+// dialRefused reports whether connections to port on every address returned
+// by hostAddrs fail, which SetDialConfirm takes as proof that nothing is
+// listening on it anymore. A timeout is ambiguous and not counted as a
+// refusal.
+func dialRefused(port int) bool {
+	for _, ip := range hostAddrs() {
+		conn, err := dialTimeout("tcp", tcpAddr(ip, port).String(), dialConfirmTimeout)
+		if err == nil {
+			conn.Close()
+			return false
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	hostAddrsOnce sync.Once
+	hostIPs       []string
+)
+
+// hostAddrs returns the non-loopback IPv4 addresses of this host. A server
+// listening on one of them doesn't keep the bind on 127.0.0.1 from
+// succeeding, unlike servers on the wildcard address or on IPv6 addresses,
+// which the bind on [::] catches.
+func hostAddrs() []string {
+	hostAddrsOnce.Do(func() {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			logf("WARN", "cannot list the host addresses for dial confirmation: %v", err)
+			return
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+				continue
+			}
+			hostIPs = append(hostIPs, ipNet.IP.String())
+		}
+	})
+	return hostIPs
+}
+
+// expectNoInbound fails if a connection arrives on ln within
+// strictVerifyWindow, which means something is still trying to talk to the
+// port.
+func expectNoInbound(ln net.Listener) error {
+	dl, ok := ln.(interface{ SetDeadline(time.Time) error })
+	if !ok {
+		return nil
+	}
+	if err := dl.SetDeadline(time.Now().Add(strictVerifyWindow)); err != nil {
+		return err
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil
+		}
+		return err
+	}
+	conn.Close()
+	return fmt.Errorf("freeport: unexpected inbound connection from %s", conn.RemoteAddr())
 }
 
 func tcpAddr(ip string, port int) *net.TCPAddr {
@@ -417,6 +1773,27 @@ func intervalOverlap(min1, max1, min2, max2 int) bool {
 	return min1 <= max2 && min2 <= max1
 }
 
+// logPort and logPorts defer rendering of ports in log lines to the formatter
+// installed with SetPortFormatter until the line is actually formatted.
+type logPort int
+
+func (p logPort) String() string {
+	if format := portFormatter.Load(); format != nil {
+		return (*format)(int(p))
+	}
+	return strconv.Itoa(int(p))
+}
+
+type logPorts []int
+
+func (ps logPorts) String() string {
+	parts := make([]string, len(ps))
+	for i, p := range ps {
+		parts[i] = logPort(p).String()
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
 func logf(severity string, format string, a ...interface{}) {
 	fmt.Fprintf(os.Stderr, "["+severity+"] freeport: "+format+"\n", a...)
 }
@@ -441,7 +1818,7 @@ func GetN(t TestingT, n int) []int {
 	if err != nil {
 		t.Fatalf("failed to take %v ports: %w", n, err)
 	}
-	logf("DEBUG", "Test %q took ports %v", t.Name(), ports)
+	logf("DEBUG", "Test %q took ports %v", t.Name(), logPorts(ports))
 	mu.Lock()
 	for _, p := range ports {
 		portLastUser[p] = t.Name()
@@ -449,7 +1826,7 @@ func GetN(t TestingT, n int) []int {
 	mu.Unlock()
 	t.Cleanup(func() {
 		Return(ports)
-		logf("DEBUG", "Test %q returned ports %v", t.Name(), ports)
+		logf("DEBUG", "Test %q returned ports %v", t.Name(), logPorts(ports))
 	})
 	return ports
 }
@@ -4,9 +4,11 @@
 package freeport
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"runtime"
 	"testing"
 	"time"
 
@@ -84,21 +86,21 @@ func TestTakeReturn(t *testing.T) {
 	func() {
 		ports, err := Take(numTotal + 1)
 		defer Return(ports)
-		expectError("freeport: block size too small", err)
+		expectError(fmt.Sprintf("freeport: block size too small: cannot take %d ports (free=%d, total=%d)", numTotal+1, numTotal, numTotal), err)
 	}()
 
 	// --------------------
 	// ERROR: invalid ports request (negative)
 	func() {
 		_, err := Take(-1)
-		expectError("freeport: cannot take -1 ports", err)
+		expectError("freeport: invalid port count: cannot take -1 ports", err)
 	}()
 
 	// --------------------
 	// ERROR: invalid ports request (zero)
 	func() {
 		_, err := Take(0)
-		expectError("freeport: cannot take 0 ports", err)
+		expectError("freeport: invalid port count: cannot take 0 ports", err)
 	}()
 
 	// --------------------
@@ -195,7 +197,7 @@ func TestTakeReturn(t *testing.T) {
 
 		// 3. Request 1 port which will detect the leaked ports and fail.
 		_, err := Take(1)
-		expectError("freeport: impossible to satisfy request; there are no actual free ports in the block anymore", err)
+		expectError("freeport: impossible to satisfy request: cannot take 1 ports; there are no actual free ports in the block anymore", err)
 
 		// 4. Wait for the block to zero out.
 		newNumTotal := waitForStatsReset()
@@ -407,3 +409,874 @@ func TestCLReservePortsEnvVar(t *testing.T) {
 		})
 	}
 }
+
+// waitForStatsReset waits until all returned ports have passed
+// re-verification and returns the total number of ports in the block.
+func waitForStatsReset(t *testing.T) (numTotal int) {
+	t.Helper()
+	numTotal, numPending, numFree := stats()
+	if numTotal != numFree+numPending {
+		t.Fatalf("expected total (%d) and free+pending (%d) ports to match", numTotal, numFree+numPending)
+	}
+	assert.Eventually(t, func() bool {
+		numTotal, numPending, numFree = stats()
+		return numTotal == numFree && numPending == 0
+	}, 5*time.Second, 100*time.Millisecond, "expected total (%d) and free (%d) ports to match", numTotal, numFree)
+
+	return numTotal
+}
+
+func TestMaxUsed(t *testing.T) {
+	defer reset()
+
+	assert.Equal(t, 0, MaxUsed())
+
+	first, err := Take(3)
+	assert.NoError(t, err)
+	second, err := Take(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, MaxUsed())
+
+	Return(first)
+	Return(second)
+	waitForStatsReset(t)
+
+	ports, err := Take(1)
+	assert.NoError(t, err)
+	defer Return(ports)
+	assert.Equal(t, 5, MaxUsed())
+}
+
+func TestTakeWithProgress(t *testing.T) {
+	defer reset()
+
+	numTotal := func() int {
+		ports, err := Take(1)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		Return(ports)
+		return waitForStatsReset(t)
+	}()
+
+	held, err := Take(numTotal)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type reply struct {
+		ports []int
+		err   error
+	}
+	startWaiter := func() (<-chan int, <-chan reply) {
+		posCh := make(chan int, 10)
+		replyCh := make(chan reply, 1)
+		go func() {
+			ports, err := TakeWithProgress(1, func(pos int) { posCh <- pos })
+			replyCh <- reply{ports: ports, err: err}
+		}()
+		return posCh, replyCh
+	}
+
+	firstPos, firstReply := startWaiter()
+	assert.Equal(t, 0, <-firstPos)
+	secondPos, secondReply := startWaiter()
+	assert.Equal(t, 1, <-secondPos)
+
+	Return(held)
+	for _, ch := range []<-chan reply{firstReply, secondReply} {
+		r := <-ch
+		if r.err != nil {
+			t.Fatalf("err: %v", r.err)
+		}
+		Return(r.ports)
+	}
+	waitForStatsReset(t)
+}
+
+func TestCLFreeportMaxBlockEnvVar(t *testing.T) {
+	// NOTE: for global var reasons this cannot execute in parallel
+	// t.Parallel()
+
+	// Since this test modifies global state, reset after it runs
+	defer reset()
+
+	testCases := []struct {
+		name         string
+		reservePorts string
+		maxBlock     string
+		expectedSize int
+	}{
+		{
+			name:         "clamps_large_block_size",
+			reservePorts: "4096",
+			maxBlock:     "256",
+			expectedSize: 256,
+		},
+		{
+			name:         "clamps_default_block_size",
+			reservePorts: "",
+			maxBlock:     "512",
+			expectedSize: 512,
+		},
+		{
+			name:         "below_max_is_unchanged",
+			reservePorts: "128",
+			maxBlock:     "256",
+			expectedSize: 128,
+		},
+		{
+			name:         "clamps_to_one",
+			reservePorts: "128",
+			maxBlock:     "1",
+			expectedSize: 1,
+		},
+		{
+			name:         "invalid_zero_value",
+			reservePorts: "128",
+			maxBlock:     "0",
+			expectedSize: 128, // should not clamp
+		},
+		{
+			name:         "invalid_non_numeric",
+			reservePorts: "128",
+			maxBlock:     "lots",
+			expectedSize: 128, // should not clamp
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Reset state before each test case
+			reset()
+			t.Setenv("CL_RESERVE_PORTS", tc.reservePorts)
+			t.Setenv("CL_FREEPORT_MAX_BLOCK", tc.maxBlock)
+			initialize()
+
+			assert.Equal(t, tc.expectedSize, blockSize)
+		})
+	}
+}
+
+func TestCLFreeportRangeEnvVar(t *testing.T) {
+	// NOTE: for global var reasons this cannot execute in parallel
+	// t.Parallel()
+
+	// Since this test modifies global state, reset after it runs
+	defer reset()
+
+	testCases := []struct {
+		name         string
+		envValue     string
+		expectedBase int
+		expectedSize int
+		shouldUseEnv bool
+	}{
+		{
+			name:         "valid_range",
+			envValue:     "30000-30063",
+			expectedBase: 30000,
+			expectedSize: 64,
+			shouldUseEnv: true,
+		},
+		{
+			name:         "invalid_reversed_range",
+			envValue:     "30063-30000",
+			shouldUseEnv: false,
+		},
+		{
+			name:         "invalid_single_port",
+			envValue:     "30000-30000",
+			shouldUseEnv: false,
+		},
+		{
+			name:         "invalid_non_numeric",
+			envValue:     "low-high",
+			shouldUseEnv: false,
+		},
+		{
+			name:         "empty_env_var",
+			envValue:     "",
+			shouldUseEnv: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Reset state before each test case
+			reset()
+			t.Setenv("CL_RESERVE_PORTS", "128")
+			t.Setenv("CL_FREEPORT_RANGE", tc.envValue)
+			initialize()
+
+			if tc.shouldUseEnv {
+				assert.Equal(t, tc.expectedBase, firstPort)
+				assert.Equal(t, tc.expectedSize, blockSize)
+			} else {
+				assert.Equal(t, 128, blockSize)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		in       string
+		min, max int
+		err      bool
+	}{
+		{"30000-30255", 30000, 30255, false},
+		{" 30000 - 30255 ", 30000, 30255, false},
+		{"1-1", 1, 1, false},
+		{"30255-30000", 0, 0, true},
+		{"0-10", 0, 0, true},
+		{"1-65536", 0, 0, true},
+		{"30000", 0, 0, true},
+		{"a-b", 0, 0, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			min, max, err := parseRange(tc.in)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.min, min)
+			assert.Equal(t, tc.max, max)
+		})
+	}
+}
+
+func TestPauseVerification(t *testing.T) {
+	defer reset()
+
+	ports, err := Take(3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	PauseVerification()
+	Return(ports)
+	time.Sleep(500 * time.Millisecond)
+	if _, numPending, _ := stats(); numPending != 3 {
+		t.Fatalf("expected %d pending ports while paused but got %d", 3, numPending)
+	}
+
+	ResumeVerification()
+	waitForStatsReset(t)
+}
+
+func TestTakeNeverReturnsPendingPorts(t *testing.T) {
+	defer reset()
+
+	ports, err := Take(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	PauseVerification()
+	defer ResumeVerification()
+
+	// Returning a port twice, or a port that was never taken, must not put it
+	// on the free or pending lists a second time.
+	Return(ports)
+	Return(ports)
+	Return([]int{peekFree()})
+	if _, numPending, _ := stats(); numPending != 2 {
+		t.Fatalf("expected %d pending ports but got %d", 2, numPending)
+	}
+
+	// Under churn, no port is ever handed out while it is pending or held.
+	held := map[int]bool{}
+	for i := 0; i < 50; i++ {
+		got, err := Take(5)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		mu.Lock()
+		for _, port := range got {
+			for elem := pendingPorts.Front(); elem != nil; elem = elem.Next() {
+				if elem.Value.(int) == port {
+					t.Fatalf("Take handed out pending port %d", port)
+				}
+			}
+			if held[port] {
+				t.Fatalf("Take handed out held port %d", port)
+			}
+			held[port] = true
+		}
+		mu.Unlock()
+		if i%2 == 0 {
+			Return(got)
+			for _, port := range got {
+				delete(held, port)
+			}
+		}
+	}
+
+	ResumeVerification()
+	for port := range held {
+		Return([]int{port})
+	}
+	Return(ports)
+	waitForStatsReset(t)
+}
+
+func TestProbe(t *testing.T) {
+	port, release := Scratch()
+	if err := Probe(port); err == nil {
+		t.Fatalf("expected probe of held port %d to fail", port)
+	}
+
+	release()
+	if err := Probe(port); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	// the probe listener must not linger
+	if err := Probe(port); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := Probe(0); err == nil {
+		t.Fatalf("expected error for port 0")
+	}
+}
+
+func TestReserveFree(t *testing.T) {
+	defer reset()
+	defer SetReserveFree(0)
+
+	numTotal := func() int {
+		ports, err := Take(1)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		Return(ports)
+		return waitForStatsReset(t)
+	}()
+
+	SetReserveFree(5)
+
+	_, err := Take(numTotal - 4)
+	if !errors.Is(err, ErrBlockTooSmall) {
+		t.Fatalf("expected block size error but got %v", err)
+	}
+
+	ordinary, err := Take(numTotal - 5)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Ordinary takes wait rather than dip into the reserve.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ports, err := Take(1)
+		if err == nil {
+			Return(ports)
+		}
+	}()
+	select {
+	case <-done:
+		t.Fatalf("expected Take to block on the reserve")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	reserved, err := TakeReserved(5)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	Return(ordinary)
+	Return(reserved)
+	<-done
+	waitForStatsReset(t)
+}
+
+func TestTakenPorts(t *testing.T) {
+	defer reset()
+
+	if got := TakenPorts(); len(got) != 0 {
+		t.Fatalf("expected no taken ports but got %v", got)
+	}
+
+	ports, err := Take(3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	got := TakenPorts()
+	assert.ElementsMatch(t, ports, got)
+
+	// Mutating the copy must not affect the pool.
+	got[0] = 1
+	assert.ElementsMatch(t, ports, TakenPorts())
+
+	Return(ports[:1])
+	assert.ElementsMatch(t, ports[1:], TakenPorts())
+	Return(ports[1:])
+	waitForStatsReset(t)
+}
+
+func TestReturnErr(t *testing.T) {
+	defer reset()
+
+	ports, err := Take(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	outside := firstPort + blockSize
+
+	err = ReturnErr([]int{ports[0], outside})
+	assert.EqualError(t, err, fmt.Sprintf("freeport: cannot return port %d outside of the port block [%d, %d]", outside, firstPort+1, firstPort+blockSize-1))
+	assert.Equal(t, []int{ports[1]}, TakenPorts(), "expected the valid port to be returned anyway")
+
+	err = ReturnErr([]int{ports[0]})
+	assert.EqualError(t, err, fmt.Sprintf("freeport: cannot return port %d which is not taken", ports[0]))
+
+	SetValidateReturns(true)
+	defer SetValidateReturns(false)
+	assert.NotPanics(t, func() { Return([]int{ports[1], outside}) })
+	assert.Empty(t, TakenPorts(), "expected the valid port to be returned anyway")
+
+	// Returning twice is a common cleanup pattern and must stay harmless.
+	assert.NotPanics(t, func() { Return([]int{ports[1]}) })
+	waitForStatsReset(t)
+}
+
+func TestStrictVerify(t *testing.T) {
+	defer SetStrictVerify(false)
+	SetStrictVerify(true)
+
+	port, release := Scratch()
+	release()
+	if err := Probe(port); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Keep dialing the port so that the probe listener sees a connection.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if conn, err := net.DialTimeout("tcp", tcpAddr("127.0.0.1", port).String(), 10*time.Millisecond); err == nil {
+				conn.Close()
+			}
+		}
+	}()
+	assert.Eventually(t, func() bool {
+		return Probe(port) != nil
+	}, 5*time.Second, 10*time.Millisecond, "expected strict probe to detect inbound connections")
+}
+
+func TestStrictVerifyInit(t *testing.T) {
+	defer reset()
+	defer SetStrictVerify(false)
+	t.Setenv("CL_RESERVE_PORTS", "256")
+	SetStrictVerify(true)
+
+	// Filling the pool must not wait out the strict window for every port.
+	start := time.Now()
+	ports, err := Take(1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected initialization without per-port strict windows, took %v", elapsed)
+	}
+	Return(ports)
+}
+
+func TestStabilityCheck(t *testing.T) {
+	defer SetStabilityCheck(0)
+	SetStabilityCheck(200 * time.Millisecond)
+
+	port, release := Scratch()
+	release()
+	if err := Probe(port); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Grab the port only after the first bind of the probe has succeeded.
+	grabbed := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", port))
+		if err != nil {
+			grabbed <- nil
+			return
+		}
+		grabbed <- ln
+	}()
+	err := Probe(port)
+	if ln := <-grabbed; ln != nil {
+		defer ln.Close()
+	} else {
+		t.Skip("could not grab the port between the two binds")
+	}
+	assert.ErrorContains(t, err, "not stably free")
+}
+
+func TestStabilityCheckTake(t *testing.T) {
+	defer reset()
+	defer SetStabilityCheck(0)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+	SetStabilityCheck(time.Second)
+
+	// Only the port handed out pays for the check, and other callers are
+	// not stalled while it runs.
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ports, err := Take(1)
+		assert.NoError(t, err)
+		Return(ports)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	locked := time.Now()
+	Initialized()
+	if elapsed := time.Since(locked); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the stability check to run without the lock held, Initialized took %v", elapsed)
+	}
+	<-done
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected a single stability check, took %v", elapsed)
+	}
+}
+
+func TestEphemeralRange(t *testing.T) {
+	min, max, guessed := EphemeralRange()
+	if min <= 0 || max > 65535 || min > max {
+		t.Fatalf("bad ephemeral range [%d, %d]", min, max)
+	}
+	switch runtime.GOOS {
+	case "linux", "darwin":
+		assert.False(t, guessed)
+	default:
+		assert.True(t, guessed)
+		assert.Equal(t, defaultEphemeralPortMin, min)
+		assert.Equal(t, defaultEphemeralPortMax, max)
+	}
+}
+
+func TestInitialized(t *testing.T) {
+	defer reset()
+
+	reset()
+	assert.False(t, Initialized())
+	assert.False(t, Initialized(), "Initialized must not initialize")
+
+	ports, err := Take(1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.True(t, Initialized())
+	Return(ports)
+
+	reset()
+	assert.False(t, Initialized())
+}
+
+func TestCapProbeCount(t *testing.T) {
+	// Within the limit nothing changes.
+	assert.Equal(t, -1, capProbeCount(-1, 100, 1024))
+	assert.Equal(t, 50, capProbeCount(50, 100, 1024))
+
+	// Eager verification is capped at half of the limit.
+	assert.Equal(t, 128, capProbeCount(-1, 1000, 256))
+	assert.Equal(t, 128, capProbeCount(500, 1000, 256))
+}
+
+func TestDialConfirm(t *testing.T) {
+	defer reset()
+	defer SetDialConfirm(false)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	addrs := hostAddrs()
+	if len(addrs) == 0 {
+		t.Skip("no non-loopback IPv4 address to listen on")
+	}
+
+	SetDialConfirm(true)
+	ports, err := Take(1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A server on a host address doesn't fail the bind check on 127.0.0.1,
+	// only the dial catches it.
+	ln, err := net.ListenTCP("tcp", tcpAddr(addrs[0], ports[0]))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.NoError(t, Probe(ports[0]))
+	assert.False(t, dialRefused(ports[0]), "a listening port must not count as refused")
+
+	Return(ports)
+	time.Sleep(3 * currentReverifyInterval())
+	_, numPending, _ := stats()
+	assert.Equal(t, 1, numPending, "the port must stay pending while the server is up")
+
+	ln.Close()
+	assert.True(t, dialRefused(ports[0]))
+	waitForStatsReset(t)
+}
+
+func TestDialConfirmUnlocked(t *testing.T) {
+	defer reset()
+	defer SetDialConfirm(false)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	if len(hostAddrs()) == 0 {
+		t.Skip("no non-loopback IPv4 address to dial")
+	}
+
+	dialing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		select {
+		case dialing <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil, errors.New("connection refused")
+	}
+	defer func() { dialTimeout = net.DialTimeout }()
+
+	SetDialConfirm(true)
+	ports, err := Take(1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	Return(ports)
+	<-dialing
+
+	// The pool stays usable while the returned port is being dialed.
+	more, err := Take(1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	Return(more)
+
+	close(release)
+	waitForStatsReset(t)
+}
+
+func TestReinitializeFromEnv(t *testing.T) {
+	defer reset()
+
+	// Before initialization there is nothing to do.
+	if err := ReinitializeFromEnv(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	t.Setenv("CL_RESERVE_PORTS", "64")
+	ports, err := Take(1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	numTotal, _, _ := stats()
+	assert.Equal(t, 63, numTotal)
+
+	t.Setenv("CL_RESERVE_PORTS", "32")
+	if _, err := Take(1); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	mu.Lock()
+	assert.True(t, envChangeWarned)
+	mu.Unlock()
+
+	// The change is only picked up once every port is back.
+	err = ReinitializeFromEnv()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "2 ports still taken")
+	}
+
+	Return(ports)
+	Return(TakenPorts())
+	if err := ReinitializeFromEnv(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	numTotal, _, _ = stats()
+	assert.Equal(t, 31, numTotal)
+	mu.Lock()
+	assert.False(t, envChangeWarned)
+	mu.Unlock()
+}
+
+func TestAvailable(t *testing.T) {
+	defer reset()
+	defer SetReserveFree(0)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	assert.Equal(t, 7, Available())
+
+	ports, err := Take(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(t, 5, Available())
+
+	// Returned ports only count once they have been verified again.
+	Return(ports)
+	assert.Equal(t, 5, Available())
+	waitForStatsReset(t)
+	assert.Equal(t, 7, Available())
+
+	SetReserveFree(3)
+	assert.Equal(t, 4, Available())
+	SetReserveFree(10)
+	assert.Equal(t, 0, Available())
+}
+
+func TestErrors(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	_, err := Take(0)
+	assert.ErrorIs(t, err, ErrInvalidCount)
+	assert.EqualError(t, err, "freeport: invalid port count: cannot take 0 ports")
+
+	_, err = Take(8)
+	assert.ErrorIs(t, err, ErrBlockTooSmall)
+	assert.EqualError(t, err, "freeport: block size too small: cannot take 8 ports (free=7, total=7)")
+
+	// Steal every port so that nothing can be taken anymore.
+	for _, port := range peekAllFree() {
+		ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", port))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer ln.Close()
+	}
+	_, err = Take(1)
+	assert.ErrorIs(t, err, ErrExhausted)
+}
+
+func TestProbeIPv6(t *testing.T) {
+	if !hasIPv6() {
+		t.Skip("IPv6 is not available")
+	}
+
+	// An IPv6-only listener doesn't conflict with the IPv4 bind but must still
+	// make the port count as in use.
+	ln, err := net.ListenTCP("tcp6", tcpAddr("::1", 0))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	v4, err := net.ListenTCP("tcp4", tcpAddr("127.0.0.1", port))
+	if err != nil {
+		t.Skipf("IPv6 listener also blocks IPv4 on this host: %v", err)
+	}
+	v4.Close()
+	assert.Error(t, Probe(port))
+}
+
+func TestTakeAll(t *testing.T) {
+	defer reset()
+	defer SetReserveFree(0)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	first, err := Take(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	SetReserveFree(1)
+
+	all, err := TakeAll()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Len(t, all, 4)
+	assert.Equal(t, 1, len(peekAllFree()))
+
+	// Nothing left apart from the reserve.
+	none, err := TakeAll()
+	assert.NoError(t, err)
+	assert.NotNil(t, none)
+	assert.Empty(t, none)
+
+	Return(first)
+	Return(all)
+}
+
+func TestTakeExhaustedMidwayPutsBack(t *testing.T) {
+	defer reset()
+	defer SetReserveFree(0)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+	SetReserveFree(2)
+
+	// While the second port is verified, everything else is stolen, which
+	// leaves only the reserve in the block.
+	ft := &fakeT{name: "midway"}
+	defer ft.runCleanups()
+	SetVerifierForTesting(ft, func(port int) (bool, error) {
+		if port == 30002 {
+			mu.Lock()
+			for freePorts.Len() > 0 {
+				stolenLocked(freePorts.Remove(freePorts.Front()).(int))
+			}
+			mu.Unlock()
+		}
+		return true, nil
+	})
+
+	_, err := Take(3)
+	assert.ErrorIs(t, err, ErrExhausted)
+	assert.Empty(t, TakenPorts(), "the ports taken before the failure must be put back")
+	assert.Equal(t, []int{30001, 30002}, peekAllFree())
+}
+
+func TestReinitializeDuringVerification(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	verifying := make(chan struct{})
+	proceed := make(chan struct{})
+	ft := &fakeT{name: "inflight"}
+	defer ft.runCleanups()
+	SetVerifierForTesting(ft, func(port int) (bool, error) {
+		close(verifying)
+		<-proceed
+		return true, nil
+	})
+
+	type result struct {
+		ports []int
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		ports, err := Take(1)
+		done <- result{ports, err}
+	}()
+	<-verifying
+
+	// The port being verified is neither free nor taken, but the block must
+	// not be replaced under it.
+	t.Setenv("CL_FREEPORT_RANGE", "30100-30107")
+	err := ReinitializeFromEnv()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "1 ports still taken")
+	}
+
+	close(proceed)
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("err: %v", res.err)
+	}
+	assert.Equal(t, []int{30001}, res.ports)
+	assert.Equal(t, res.ports, TakenPorts())
+	Return(res.ports)
+}
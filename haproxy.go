@@ -0,0 +1,95 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// WriteHAProxyBackends writes an HAProxy configuration fragment to path with
+// one backend section per entry in backends and one server line per port,
+// pointing at 127.0.0.1. Backends are written in sorted order and servers are
+// named after their backend, e.g.
+//
+//	backend api
+//	    server api1 127.0.0.1:30001
+//	    server api2 127.0.0.1:30002
+//
+// The file is replaced atomically.
+func WriteHAProxyBackends(path string, backends map[string][]int) error {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		if !validHAProxyName(name) {
+			return fmt.Errorf("freeport: invalid HAProxy backend name %q", name)
+		}
+		for _, port := range backends[name] {
+			if port <= 0 || port > 65535 {
+				return fmt.Errorf("freeport: invalid port %d for HAProxy backend %q", port, name)
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "backend %s\n", name)
+		for j, port := range backends[name] {
+			fmt.Fprintf(&buf, "    server %s%d 127.0.0.1:%d\n", name, j+1, port)
+		}
+	}
+
+	return writeFileAtomic(path, buf.Bytes(), 0o644)
+}
+
+// TakeHAProxyBackends takes servers[name] ports for each backend and writes
+// them to path with WriteHAProxyBackends. If the file cannot be written the
+// ports are returned to the pool.
+func TakeHAProxyBackends(path string, servers map[string]int) (map[string][]int, error) {
+	names := make([]string, 0, len(servers))
+	n := 0
+	for name, count := range servers {
+		if count <= 0 {
+			return nil, fmt.Errorf("freeport: invalid server count %d for HAProxy backend %q", count, name)
+		}
+		names = append(names, name)
+		n += count
+	}
+	sort.Strings(names)
+
+	ports, err := Take(n)
+	if err != nil {
+		return nil, err
+	}
+
+	backends := make(map[string][]int, len(names))
+	rest := ports
+	for _, name := range names {
+		backends[name], rest = rest[:servers[name]], rest[servers[name]:]
+	}
+
+	if err := WriteHAProxyBackends(path, backends); err != nil {
+		Return(ports)
+		return nil, err
+	}
+	return backends, nil
+}
+
+// validHAProxyName reports whether name may be used as an HAProxy proxy name.
+func validHAProxyName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' || r == ':') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteHAProxyBackends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.cfg")
+	require.NoError(t, WriteHAProxyBackends(path, map[string][]int{"web": {20003}, "api": {20001, 20002}}))
+
+	out, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `backend api
+    server api1 127.0.0.1:20001
+    server api2 127.0.0.1:20002
+
+backend web
+    server web1 127.0.0.1:20003
+`, string(out))
+
+	assert.Error(t, WriteHAProxyBackends(path, map[string][]int{"bad name": {20001}}))
+	assert.Error(t, WriteHAProxyBackends(path, map[string][]int{"api": {0}}))
+}
+
+func TestTakeHAProxyBackends(t *testing.T) {
+	defer reset()
+
+	path := filepath.Join(t.TempDir(), "backends.cfg")
+	backends, err := TakeHAProxyBackends(path, map[string]int{"api": 2, "web": 1})
+	require.NoError(t, err)
+	require.Len(t, backends["api"], 2)
+	require.Len(t, backends["web"], 1)
+	defer Return(append(append([]int(nil), backends["api"]...), backends["web"]...))
+
+	out, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), fmt.Sprintf("    server web1 127.0.0.1:%d\n", backends["web"][0]))
+
+	_, err = TakeHAProxyBackends(path, map[string]int{"api": 0})
+	assert.Error(t, err)
+}
@@ -0,0 +1,49 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "net"
+
+// ReleaseHold closes the listener that Take kept open on port because
+// SetHoldDuringTake is enabled, so that the caller can bind the port. It is a
+// no-op for ports without a hold.
+func ReleaseHold(port int) {
+	mu.Lock()
+	defer mu.Unlock()
+	releaseHoldLocked(port)
+}
+
+// holdLocked opens a listener on a port that is being handed out if
+// SetHoldDuringTake is enabled. It must be called with mu held.
+func holdLocked(port int) {
+	if !holdDuringTake {
+		return
+	}
+	ln, err := listenTCP("tcp", tcpAddr("127.0.0.1", port))
+	if err != nil {
+		logf("WARN", "unable to hold port %v: %v", logPort(port), err)
+		return
+	}
+	holds[port] = ln
+}
+
+// listenOnTaken opens a listener on 127.0.0.1 on a port taken from the pool,
+// closing the hold Take may keep on it first so that the bind does not fail.
+// The hold is released and the port bound under mu so that no other caller of
+// freeport can get in between.
+func listenOnTaken(port int) (net.Listener, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	releaseHoldLocked(port)
+	return listenTCP("tcp", tcpAddr("127.0.0.1", port))
+}
+
+// releaseHoldLocked closes the hold on port, if any. It must be called with
+// mu held.
+func releaseHoldLocked(port int) {
+	if ln, ok := holds[port]; ok {
+		ln.Close()
+		delete(holds, port)
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHoldDuringTake(t *testing.T) {
+	defer reset()
+	defer SetHoldDuringTake(false)
+	SetHoldDuringTake(true)
+
+	ports, err := Take(2)
+	require.NoError(t, err)
+	for _, port := range ports {
+		assert.True(t, isPortInUse(port), "expected port %d to be held", port)
+	}
+
+	ReleaseHold(ports[0])
+	ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", ports[0]))
+	require.NoError(t, err)
+	require.NoError(t, ln.Close())
+
+	// Returning a port drops its hold too.
+	Return(ports)
+	waitForStatsReset(t)
+}
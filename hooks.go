@@ -0,0 +1,175 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hookEvent is a take or return waiting to be reported to the hooks.
+type hookEvent struct {
+	take  bool
+	ports []int
+
+	// held is set instead of take for the OnReturnWithDuration hook, with
+	// how long each of ports was held.
+	held []time.Duration
+
+	// at and label are only recorded for the audit log.
+	at    time.Time
+	label string
+}
+
+var (
+	// onTake and onReturn are the hooks set with SetOnTake and SetOnReturn.
+	// They are guarded by mu.
+	onTake   func(ports []int)
+	onReturn func(ports []int)
+
+	// onReturnDuration is the hook set with OnReturnWithDuration. It is
+	// guarded by mu.
+	onReturnDuration func(port int, heldFor time.Duration)
+
+	// hookQueue holds the events that have not been reported yet, in the
+	// order they happened. It is guarded by mu.
+	hookQueue []hookEvent
+
+	// hooksSet is set while a hook or the audit log is installed and lets
+	// runHooks skip taking the locks otherwise.
+	hooksSet atomic.Bool
+
+	// hookMu serializes runHooks so that events are reported in order and a
+	// caller's own event has been reported by the time runHooks returns.
+	hookMu sync.Mutex
+)
+
+// SetOnTake sets a function that is called with the ports of every successful
+// take, before the take returns to its caller, e.g. to register them with a
+// service registry. Passing nil removes the hook. The hook is called without
+// any internal locks held but must not take or return ports itself. A panic in
+// the hook is recovered and logged.
+func SetOnTake(fn func(ports []int)) {
+	mu.Lock()
+	defer mu.Unlock()
+	onTake = fn
+	updateHooksSetLocked()
+}
+
+// SetOnReturn is like SetOnTake but for the ports accepted by every return,
+// including ports reclaimed from expired leases and revoked soft reservations.
+func SetOnReturn(fn func(ports []int)) {
+	mu.Lock()
+	defer mu.Unlock()
+	onReturn = fn
+	updateHooksSetLocked()
+}
+
+// OnReturnWithDuration sets a function that is called for every port accepted
+// by a return with how long the port was held since it was taken, e.g. to
+// find ports held far longer than a test should take, which points at leaks
+// or servers that are slow to shut down. It covers every way ports are
+// returned, like SetOnReturn, and is called under the same rules. Passing nil
+// removes the hook.
+func OnReturnWithDuration(fn func(port int, heldFor time.Duration)) {
+	mu.Lock()
+	defer mu.Unlock()
+	onReturnDuration = fn
+	updateHooksSetLocked()
+}
+
+// updateHooksSetLocked updates hooksSet after a hook or the audit log has
+// been changed. It must be called with mu held.
+func updateHooksSetLocked() {
+	hooksSet.Store(onTake != nil || onReturn != nil || onReturnDuration != nil || auditLog != nil)
+}
+
+// queueHookLocked records an event for the hooks, if there are any. It must be
+// called with mu held.
+func queueHookLocked(take bool, ports []int) {
+	if len(ports) == 0 {
+		return
+	}
+	if auditLog == nil && ((take && onTake == nil) || (!take && onReturn == nil)) {
+		return
+	}
+	ev := hookEvent{take: take, ports: append([]int(nil), ports...)}
+	if auditLog != nil {
+		ev.at = time.Now()
+		ev.label = portLastUser[ports[0]]
+	}
+	hookQueue = append(hookQueue, ev)
+}
+
+// queueDurationsLocked records the hold durations of returned ports for the
+// OnReturnWithDuration hook. It must be called with mu held.
+func queueDurationsLocked(ports []int, held []time.Duration) {
+	if len(ports) == 0 || onReturnDuration == nil {
+		return
+	}
+	hookQueue = append(hookQueue, hookEvent{ports: append([]int(nil), ports...), held: held})
+}
+
+// runHooks reports the queued events to the hooks. Every function that takes
+// or returns ports defers it before locking mu, so that it runs after mu has
+// been released.
+func runHooks() {
+	if !hooksSet.Load() {
+		return
+	}
+
+	hookMu.Lock()
+	defer hookMu.Unlock()
+
+	mu.Lock()
+	queue := hookQueue
+	hookQueue = nil
+	takeFn, returnFn, durationFn := onTake, onReturn, onReturnDuration
+	audit := auditLog
+	mu.Unlock()
+
+	for _, ev := range queue {
+		if ev.held != nil {
+			callDurationHook(durationFn, ev.ports, ev.held)
+			continue
+		}
+		if audit != nil && !ev.at.IsZero() {
+			writeAudit(audit, ev)
+		}
+		if ev.take {
+			callHook("take", takeFn, ev.ports)
+		} else {
+			callHook("return", returnFn, ev.ports)
+		}
+	}
+}
+
+func callHook(kind string, fn func(ports []int), ports []int) {
+	if fn == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			logf("WARN", "%s hook panicked for ports %v: %v", kind, logPorts(ports), r)
+		}
+	}()
+	fn(ports)
+}
+
+func callDurationHook(fn func(port int, heldFor time.Duration), ports []int, held []time.Duration) {
+	if fn == nil {
+		return
+	}
+	for i, port := range ports {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logf("WARN", "return duration hook panicked for port %v: %v", logPort(port), r)
+				}
+			}()
+			fn(port, held[i])
+		}()
+	}
+}
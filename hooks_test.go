@@ -0,0 +1,86 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks(t *testing.T) {
+	defer reset()
+	defer SetOnTake(nil)
+	defer SetOnReturn(nil)
+
+	registry := make(map[int]bool)
+	SetOnTake(func(ports []int) {
+		// Hooks run without the package lock held.
+		if assert.True(t, mu.TryLock()) {
+			mu.Unlock()
+		}
+		for _, port := range ports {
+			registry[port] = true
+		}
+	})
+	SetOnReturn(func(ports []int) {
+		for _, port := range ports {
+			delete(registry, port)
+		}
+	})
+
+	ports, err := Take(3)
+	require.NoError(t, err)
+	for _, port := range ports {
+		assert.True(t, registry[port], "port %d should have been registered before Take returned", port)
+	}
+	r, err := TakeContiguous(2)
+	require.NoError(t, err)
+	assert.Len(t, registry, 5)
+
+	// Only ports that are actually returned are reported.
+	Return(append(ports, ports...))
+	ReturnRange(r)
+	assert.Empty(t, registry)
+
+	// A panicking hook does not break the pool.
+	SetOnTake(func([]int) { panic("boom") })
+	ports, err = Take(1)
+	require.NoError(t, err)
+	assert.Equal(t, ports, TakenPorts())
+	Return(ports)
+}
+
+func TestOnReturnWithDuration(t *testing.T) {
+	defer reset()
+	defer OnReturnWithDuration(nil)
+
+	held := make(map[int]time.Duration)
+	OnReturnWithDuration(func(port int, heldFor time.Duration) {
+		held[port] = heldFor
+	})
+
+	long, err := Take(2)
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	short, err := TakeLabeled("short", 1)
+	require.NoError(t, err)
+
+	// Partial returns and returns by label report each port separately.
+	Return(long[:1])
+	assert.Equal(t, 1, ReturnLabel("short"))
+	Return(long[1:])
+	require.Len(t, held, 3)
+	for _, port := range long {
+		assert.GreaterOrEqual(t, held[port], 50*time.Millisecond)
+	}
+	assert.Less(t, held[short[0]], 50*time.Millisecond)
+
+	// Ports that are not taken are not reported.
+	clear(held)
+	Return(long)
+	assert.Empty(t, held)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// serveAttempts bounds how many ports ServeOnFree tries before giving up.
+const serveAttempts = 10
+
+// ServeOnFree takes one port from the pool and starts an http.Server serving
+// handler on 127.0.0.1 at that port. It returns the server, the port and a
+// cleanup function that shuts the server down and returns the port to the
+// pool. If the port turns out to be bound by someone else, ServeOnFree returns
+// it and tries another one. cleanup may be called more than once.
+func ServeOnFree(handler http.Handler) (srv *http.Server, port int, cleanup func(), err error) {
+	var ln net.Listener
+	for attempt := 0; attempt < serveAttempts; attempt++ {
+		ports, err := Take(1)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		port = ports[0]
+
+		ln, err = listenOnTaken(port)
+		if err == nil {
+			break
+		}
+		logf("WARN", "could not serve on port %v, trying another: %v", logPort(port), err)
+		Return(ports)
+		ln = nil
+	}
+	if ln == nil {
+		return nil, 0, nil, fmt.Errorf("freeport: could not bind an HTTP server after %d attempts", serveAttempts)
+	}
+
+	srv = &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logf("WARN", "HTTP server on port %v stopped: %v", logPort(port), err)
+		}
+	}()
+
+	var once sync.Once
+	cleanup = func() {
+		once.Do(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+			}
+			<-done
+			Return([]int{port})
+		})
+	}
+	return srv, port, cleanup, nil
+}
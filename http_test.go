@@ -0,0 +1,46 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeOnFree(t *testing.T) {
+	defer reset()
+
+	// Occupy the next free port; ServeOnFree must not end up on it.
+	mu.Lock()
+	once.Do(initialize)
+	stolen := freePorts.Front().Value.(int)
+	mu.Unlock()
+	ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", stolen))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	_, port, cleanup, err := ServeOnFree(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	require.NoError(t, err)
+	assert.NotEqual(t, stolen, port)
+	assert.Contains(t, TakenPorts(), port)
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", port))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+
+	cleanup()
+	cleanup()
+	assert.NotContains(t, TakenPorts(), port)
+}
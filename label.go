@@ -0,0 +1,48 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+// TakeLabeled is like Take but tags the ports with label so that they can be
+// returned together with ReturnLabel. GetN, GetOne and Collector tag their
+// ports with the name of the test.
+func TakeLabeled(label string, n int) ([]int, error) {
+	if n <= 0 {
+		return nil, invalidCountError(n)
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	ports, err := takeLocked(n)
+	if err != nil {
+		return nil, err
+	}
+	for _, port := range ports {
+		portLastUser[port] = label
+	}
+	countTake(ports)
+	return ports, nil
+}
+
+// ReturnLabel returns every outstanding port tagged with label to the pool and
+// reports how many ports were returned. An unknown label returns 0.
+func ReturnLabel(label string) int {
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	var ports []int
+	for port := range taken {
+		if user, ok := portLastUser[port]; ok && user == label {
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) > 0 {
+		returnLocked(ports, ReasonUnspecified)
+	}
+	return len(ports)
+}
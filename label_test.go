@@ -0,0 +1,31 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReturnLabel(t *testing.T) {
+	defer reset()
+
+	a, err := TakeLabeled("phase-a", 3)
+	require.NoError(t, err)
+	b, err := TakeLabeled("phase-b", 2)
+	require.NoError(t, err)
+	defer Return(b)
+
+	assert.Equal(t, 0, ReturnLabel("unknown"))
+	assert.Equal(t, 3, ReturnLabel("phase-a"))
+	assert.Equal(t, 0, ReturnLabel("phase-a"))
+
+	taken := TakenPorts()
+	assert.ElementsMatch(t, b, taken)
+	for _, port := range a {
+		assert.NotContains(t, taken, port)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"time"
+)
+
+// Lease is a set of ports that are returned to the pool automatically once
+// the lease expires, unless it is renewed first. Leases guard against
+// processes that never get around to returning their ports.
+type Lease struct {
+	ports []int
+
+	// deadline and released are guarded by mu.
+	deadline time.Time
+	released bool
+}
+
+// TakeLease takes n free ports from the reserved port block and returns them
+// as a Lease that expires after ttl. See Take for more details.
+func TakeLease(n int, ttl time.Duration) (*Lease, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("freeport: invalid lease ttl %v", ttl)
+	}
+
+	ports, err := Take(n)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Lease{ports: ports}
+
+	mu.Lock()
+	defer mu.Unlock()
+	l.deadline = time.Now().Add(ttl)
+	leases[l] = struct{}{}
+	for _, port := range ports {
+		// A port returned right after Take is not the lease's to hold.
+		if _, ok := taken[port]; ok {
+			leased[port] = l
+		}
+	}
+	return l, nil
+}
+
+// Ports returns the leased ports.
+func (l *Lease) Ports() []int {
+	return append([]int(nil), l.ports...)
+}
+
+// Renew extends the lease to expire ttl from now. Renewing fails if the lease
+// has already been reclaimed or returned; a renewal that is processed before
+// the background goroutine reclaims the lease always wins, even if the
+// previous deadline has passed.
+func (l *Lease) Renew(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("freeport: invalid lease ttl %v", ttl)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if l.released {
+		return fmt.Errorf("freeport: lease for ports %v already released", l.ports)
+	}
+	l.deadline = time.Now().Add(ttl)
+	return nil
+}
+
+// Return returns the leased ports to the pool before the lease expires. Ports
+// that were already returned some other way are skipped, even if someone else
+// has taken them since. It is safe to call more than once.
+func (l *Lease) Return() {
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	if l.released {
+		return
+	}
+	l.released = true
+	delete(leases, l)
+	returnLocked(l.heldLocked(), ReasonUnspecified)
+}
+
+// heldLocked returns the ports of the lease that have not been returned yet.
+// It must be called with mu held.
+func (l *Lease) heldLocked() []int {
+	var held []int
+	for _, port := range l.ports {
+		if leased[port] == l {
+			held = append(held, port)
+		}
+	}
+	return held
+}
+
+// expireLeases returns the ports of all expired leases to the pool.
+func expireLeases() {
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	for l := range leases {
+		if now.Before(l.deadline) {
+			continue
+		}
+		logf("WARN", "lease for ports %v expired; reclaiming", logPorts(l.ports))
+		l.released = true
+		delete(leases, l)
+		returnLocked(l.heldLocked(), ReasonLeaseExpired)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeLease(t *testing.T) {
+	defer reset()
+
+	_, err := TakeLease(1, 0)
+	require.Error(t, err)
+
+	// An expired lease is reclaimed by the background goroutine.
+	expiring, err := TakeLease(2, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.Len(t, expiring.Ports(), 2)
+	assert.Eventually(t, func() bool {
+		numTotal, numPending, numFree := stats()
+		return numTotal == numFree && numPending == 0
+	}, 5*time.Second, 100*time.Millisecond, "expected expired lease to be reclaimed")
+	require.Error(t, expiring.Renew(time.Minute))
+
+	// A renewed lease survives its original deadline.
+	renewed, err := TakeLease(2, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, renewed.Renew(time.Minute))
+	time.Sleep(500 * time.Millisecond)
+	numTotal, numPending, numFree := stats()
+	assert.Equal(t, numTotal-2, numFree+numPending)
+
+	renewed.Return()
+	renewed.Return()
+	waitForStatsReset(t)
+}
+
+func TestLeaseExpiryAfterReturn(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30002")
+
+	l, err := TakeLease(1, 50*time.Millisecond)
+	require.NoError(t, err)
+	Return(l.Ports())
+	assert.Eventually(t, func() bool {
+		_, numPending, _ := stats()
+		return numPending == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Someone else takes the port the lease gave up.
+	ports, err := Take(2)
+	require.NoError(t, err)
+	require.Contains(t, ports, l.Ports()[0])
+
+	time.Sleep(100 * time.Millisecond)
+	expireLeases()
+	l.Return()
+	assert.ElementsMatch(t, ports, TakenPorts(), "the lease must not return ports it no longer holds")
+	Return(ports)
+}
@@ -0,0 +1,32 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+)
+
+// listenConfig is the configuration all listeners are opened with, or nil for
+// the defaults of net.ListenTCP. It is read without holding mu.
+var listenConfig atomic.Pointer[net.ListenConfig]
+
+// SetListenConfig makes freeport open every listener it uses, including those
+// that verify ports are free, lock the port block, hold ports and back
+// ListenerFor, Scratch and ServeOnFree, with cfg. This lets verification
+// respect custom socket options set in cfg.Control, e.g. SO_BINDTODEVICE.
+// Passing nil restores the default behavior.
+func SetListenConfig(cfg *net.ListenConfig) {
+	listenConfig.Store(cfg)
+}
+
+// listenTCP opens a TCP listener on addr using the configuration set with
+// SetListenConfig.
+func listenTCP(network string, addr *net.TCPAddr) (net.Listener, error) {
+	if cfg := listenConfig.Load(); cfg != nil {
+		return cfg.Listen(context.Background(), network, addr.String())
+	}
+	return net.ListenTCP(network, addr)
+}
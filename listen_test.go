@@ -0,0 +1,49 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetListenConfig(t *testing.T) {
+	defer reset()
+	defer SetListenConfig(nil)
+
+	var calls atomic.Int64
+	SetListenConfig(&net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			calls.Add(1)
+			return nil
+		},
+	})
+
+	ports, err := Take(1)
+	require.NoError(t, err)
+	assert.Positive(t, calls.Load(), "expected the block lock and verification to use the config")
+	Return(ports)
+
+	before := calls.Load()
+	port, release := Scratch()
+	release()
+	assert.Greater(t, calls.Load(), before)
+
+	// A config that refuses every bind makes verification reject the port.
+	SetListenConfig(&net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return errors.New("refused")
+		},
+	})
+	assert.Error(t, Probe(port))
+
+	SetListenConfig(nil)
+	assert.NoError(t, Probe(port))
+}
@@ -0,0 +1,80 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// ListenerFor opens a TCP listener on 127.0.0.1 on a port previously taken
+// from freeport. Closing the listener returns the port to the pool, so the
+// caller must not Return it separately. Closing the listener more than once
+// returns the port only once.
+func ListenerFor(port int) (net.Listener, error) {
+	ln, err := listenOnTaken(port)
+	if err != nil {
+		return nil, err
+	}
+	return &returningListener{Listener: ln, port: port}, nil
+}
+
+// Reserve takes n ports and opens a listener on each of them with
+// ListenerFor, so that nothing else can grab the ports before the caller's
+// services use the listeners. The call is all or nothing: if a listener cannot
+// be opened, the listeners opened so far are closed and all n ports are
+// returned to the pool before the error is returned.
+func Reserve(n int) ([]net.Listener, error) {
+	ports, err := Take(n)
+	if err != nil {
+		return nil, err
+	}
+
+	lns := make([]net.Listener, 0, n)
+	for i, port := range ports {
+		ln, err := ListenerFor(port)
+		if err != nil {
+			// Closing the listeners returns their ports.
+			for _, ln := range lns {
+				ln.Close()
+			}
+			Return(ports[i:])
+			return nil, fmt.Errorf("freeport: reserve failed after opening %d of %d listeners: %w", i, n, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// returningListener is a net.Listener that returns its port to the pool when
+// it is closed.
+type returningListener struct {
+	net.Listener
+	port int
+	once sync.Once
+}
+
+func (l *returningListener) Close() error {
+	err := l.Listener.Close()
+	l.once.Do(func() { Return([]int{l.port}) })
+	return err
+}
+
+// Scratch returns an ephemeral port assigned by the operating system together
+// with a function that releases it. The port is held by an open listener until
+// release is called, so nothing else can take it in the meantime. Scratch
+// ports are independent of the reserved port block and must not be passed to
+// Return. release may be called more than once. Scratch panics if no listener
+// can be opened.
+func Scratch() (port int, release func()) {
+	ln, err := listenTCP("tcp", tcpAddr("127.0.0.1", 0))
+	if err != nil {
+		panic("freeport: cannot open scratch listener: " + err.Error())
+	}
+	var once sync.Once
+	return ln.Addr().(*net.TCPAddr).Port, func() {
+		once.Do(func() { ln.Close() })
+	}
+}
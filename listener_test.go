@@ -0,0 +1,106 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenerFor(t *testing.T) {
+	defer reset()
+
+	port := func() int {
+		ports, err := Take(1)
+		require.NoError(t, err)
+		return ports[0]
+	}()
+
+	ln, err := ListenerFor(port)
+	require.NoError(t, err)
+	assert.True(t, isPortInUse(port))
+
+	PauseVerification()
+	defer ResumeVerification()
+
+	require.NoError(t, ln.Close())
+	assert.Error(t, ln.Close())
+	_, numPending, _ := stats()
+	assert.Equal(t, 1, numPending)
+
+	ResumeVerification()
+	waitForStatsReset(t)
+}
+
+func TestReserve(t *testing.T) {
+	defer reset()
+	defer SetListenConfig(nil)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	lns, err := Reserve(2)
+	require.NoError(t, err)
+	require.Len(t, lns, 2)
+	assert.Equal(t, []int{30001, 30002}, TakenPorts())
+	for _, ln := range lns {
+		require.NoError(t, ln.Close())
+	}
+	assert.Empty(t, TakenPorts())
+	waitForStatsReset(t)
+
+	// The next take gets 30003-30005; let verification of 30005 pass but
+	// fail its listener.
+	var bindMu sync.Mutex
+	binds := make(map[string]int)
+	SetListenConfig(&net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			bindMu.Lock()
+			defer bindMu.Unlock()
+			binds[address]++
+			if address == "127.0.0.1:30005" && binds[address] > 1 {
+				return errors.New("bind refused")
+			}
+			return nil
+		},
+	})
+
+	_, err = Reserve(3)
+	assert.EqualError(t, err, "freeport: reserve failed after opening 2 of 3 listeners: listen tcp 127.0.0.1:30005: bind refused")
+	assert.Empty(t, TakenPorts())
+	SetListenConfig(nil)
+	assert.NoError(t, Probe(30003), "the opened listeners must have been closed")
+	assert.NoError(t, Probe(30004), "the opened listeners must have been closed")
+}
+
+func TestReserveWithHolds(t *testing.T) {
+	defer reset()
+	defer SetHoldDuringTake(false)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+	SetHoldDuringTake(true)
+
+	lns, err := Reserve(2)
+	require.NoError(t, err)
+	mu.Lock()
+	assert.Empty(t, holds, "the holds must make way for the listeners")
+	mu.Unlock()
+	for _, ln := range lns {
+		require.NoError(t, ln.Close())
+	}
+	assert.Empty(t, TakenPorts())
+}
+
+func TestScratch(t *testing.T) {
+	port, release := Scratch()
+	assert.NotZero(t, port)
+	assert.True(t, isPortInUse(port))
+
+	release()
+	release()
+	assert.False(t, isPortInUse(port))
+}
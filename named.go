@@ -0,0 +1,153 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TakeNamed takes one port per name and returns the mapping from name to
+// port, e.g. to feed a config template. Names must be unique and non-empty.
+func TakeNamed(names []string) (map[string]int, error) {
+	if err := validateNames(names); err != nil {
+		return nil, err
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	return takeNamedLocked(names, nil)
+}
+
+// TakeNamedStable is like TakeNamed but keeps the assignment stable across
+// runs: the mapping handed out for key is recorded in a file under os.TempDir,
+// and each name gets its recorded port again if that port is free. A name
+// whose recorded port is busy gets a fresh port and the record is updated.
+// Names not requested this time keep their recorded ports.
+func TakeNamedStable(key string, names []string) (map[string]int, error) {
+	if err := validateNames(names); err != nil {
+		return nil, err
+	}
+
+	recorded := readNamed(key)
+
+	defer runHooks()
+	mu.Lock()
+	once.Do(initialize)
+	ports, err := takeNamedLocked(names, recorded)
+	mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for name, port := range ports {
+		recorded[name] = port
+	}
+	if err := writeNamed(key, recorded); err != nil {
+		logf("WARN", "failed to record named ports for %q: %v", key, err)
+	}
+	return ports, nil
+}
+
+// takeNamedLocked implements TakeNamed, preferring the ports in preferred for
+// the names found there. It must be called with mu held and after the package
+// has been initialized.
+func takeNamedLocked(names []string, preferred map[string]int) (map[string]int, error) {
+	ports := make(map[string]int, len(names))
+	var rest []string
+	for _, name := range names {
+		if port, ok := preferred[name]; ok && takePortLocked(port) {
+			ports[name] = port
+			continue
+		}
+		rest = append(rest, name)
+	}
+
+	if len(rest) > 0 {
+		fresh, err := takeLocked(len(rest))
+		if err != nil {
+			for _, port := range ports {
+				putBackLocked(port)
+			}
+			return nil, err
+		}
+		for i, name := range rest {
+			ports[name] = fresh[i]
+		}
+	}
+
+	all := make([]int, 0, len(ports))
+	for _, name := range names {
+		all = append(all, ports[name])
+	}
+	countTake(all)
+	return ports, nil
+}
+
+func validateNames(names []string) error {
+	if len(names) == 0 {
+		return invalidCountError(0)
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, "=\n") {
+			return fmt.Errorf("freeport: invalid port name %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("freeport: duplicate port name %q", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// namedPath returns the file used to record the ports handed out for key.
+func namedPath(key string) string {
+	return stickyPath(key) + ".names"
+}
+
+// readNamed returns the recorded mapping for key, which is empty if there is
+// none.
+func readNamed(key string) map[string]int {
+	ports := make(map[string]int)
+	out, err := os.ReadFile(namedPath(key))
+	if err != nil {
+		return ports
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		name, value, found := strings.Cut(scanner.Text(), "=")
+		if !found {
+			continue
+		}
+		if port, err := strconv.Atoi(value); err == nil {
+			ports[name] = port
+		}
+	}
+	return ports
+}
+
+func writeNamed(key string, ports map[string]int) error {
+	names := make([]string, 0, len(ports))
+	for name := range ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s=%d\n", name, ports[name])
+	}
+	return writeFileAtomic(namedPath(key), buf.Bytes(), 0o644)
+}
@@ -0,0 +1,65 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func namedPorts(ports map[string]int) []int {
+	all := make([]int, 0, len(ports))
+	for _, port := range ports {
+		all = append(all, port)
+	}
+	return all
+}
+
+func TestTakeNamed(t *testing.T) {
+	defer reset()
+
+	_, err := TakeNamed(nil)
+	require.Error(t, err)
+	_, err = TakeNamed([]string{"db", "db"})
+	require.EqualError(t, err, `freeport: duplicate port name "db"`)
+
+	ports, err := TakeNamed([]string{"db", "api"})
+	require.NoError(t, err)
+	require.Len(t, ports, 2)
+	assert.NotEqual(t, ports["db"], ports["api"])
+	Return(namedPorts(ports))
+}
+
+func TestTakeNamedStable(t *testing.T) {
+	defer reset()
+	t.Setenv("TMPDIR", t.TempDir())
+
+	first, err := TakeNamedStable("config", []string{"db", "api", "metrics"})
+	require.NoError(t, err)
+	Return(namedPorts(first))
+	waitForStatsReset(t)
+
+	// The same names get the same ports back once they are free again.
+	second, err := TakeNamedStable("config", []string{"api", "db", "metrics"})
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	Return(namedPorts(second))
+	waitForStatsReset(t)
+
+	// A recorded port that is now busy is replaced and the record updated;
+	// names not requested keep their record.
+	ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", first["db"]))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	third, err := TakeNamedStable("config", []string{"db", "api"})
+	require.NoError(t, err)
+	defer Return(namedPorts(third))
+	assert.NotEqual(t, first["db"], third["db"])
+	assert.Equal(t, first["api"], third["api"])
+	assert.Equal(t, map[string]int{"db": third["db"], "api": first["api"], "metrics": first["metrics"]}, readNamed("config"))
+}
@@ -0,0 +1,72 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package freeport
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// namespaceFd is the network namespace verification binds are performed in,
+// or -1 to use the namespace of the current process.
+var namespaceFd atomic.Int64
+
+func init() {
+	namespaceFd.Store(-1)
+}
+
+// SetNamespace makes freeport verify that ports are free inside the network
+// namespace referred to by fd (e.g. an open /proc/<pid>/ns/net) instead of the
+// namespace of the current process. Passing -1 restores the default. The
+// caller retains ownership of fd and must keep it open while it is in use.
+func SetNamespace(fd int) error {
+	if fd >= 0 {
+		if err := inNamespace(fd, func() error { return nil }); err != nil {
+			return fmt.Errorf("freeport: invalid network namespace fd %d: %w", fd, err)
+		}
+	}
+	namespaceFd.Store(int64(fd))
+	return nil
+}
+
+// listenVerify opens the listener used to check whether a port is free.
+func listenVerify(network string, addr *net.TCPAddr) (net.Listener, error) {
+	fd := int(namespaceFd.Load())
+	if fd < 0 {
+		return listenTCP(network, addr)
+	}
+
+	var ln net.Listener
+	err := inNamespace(fd, func() (err error) {
+		ln, err = listenTCP(network, addr)
+		return err
+	})
+	if err != nil {
+		logf("WARN", "verification of %s in network namespace fd %d failed: %v", addr, fd, err)
+		return nil, err
+	}
+	return ln, nil
+}
+
+// inNamespace runs fn on a dedicated OS thread that has joined the network
+// namespace referred to by fd. The thread is never unlocked, so the runtime
+// discards it once fn returns rather than reusing it in the wrong namespace.
+func inNamespace(fd int, fn func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := unix.Setns(fd, unix.CLONE_NEWNET); err != nil {
+			errCh <- err
+			return
+		}
+		errCh <- fn()
+	}()
+	return <-errCh
+}
@@ -0,0 +1,41 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package freeport
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSetNamespace(t *testing.T) {
+	defer reset()
+	defer SetNamespace(-1)
+
+	// A file that is not a network namespace is rejected.
+	f, err := os.Open(os.DevNull)
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Error(t, SetNamespace(int(f.Fd())))
+
+	ns, err := os.Open("/proc/self/ns/net")
+	require.NoError(t, err)
+	defer ns.Close()
+	if err := SetNamespace(int(ns.Fd())); errors.Is(err, unix.EPERM) {
+		t.Skipf("joining a network namespace requires CAP_SYS_ADMIN: %v", err)
+	} else {
+		require.NoError(t, err)
+	}
+
+	ports, err := Take(2)
+	require.NoError(t, err)
+	Return(ports)
+	waitForStatsReset(t)
+}
@@ -0,0 +1,13 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !linux
+
+package freeport
+
+import "net"
+
+// listenVerify opens the listener used to check whether a port is free.
+func listenVerify(network string, addr *net.TCPAddr) (net.Listener, error) {
+	return listenTCP(network, addr)
+}
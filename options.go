@@ -0,0 +1,666 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ProbeStrategy determines the order in which candidate port blocks are tried
+// during initialization.
+type ProbeStrategy int
+
+const (
+	// ProbeRandom tries the candidate blocks starting from a random one. This
+	// is the default and avoids collisions between parallel processes.
+	ProbeRandom ProbeStrategy = iota
+
+	// ProbeSequential tries the candidate blocks in order starting from the
+	// lowest one, which makes the reserved block predictable.
+	ProbeSequential
+)
+
+// PortClass selects the IANA port range the port block is placed in, see
+// SetPortClass.
+type PortClass int
+
+const (
+	// PortClassAny places the block anywhere above the minimum port. This is
+	// the default.
+	PortClassAny PortClass = iota
+
+	// PortClassDynamic places the block in the dynamic/private range
+	// 49152-65535, which IANA never assigns to services.
+	PortClassDynamic
+
+	// PortClassRegistered places the block in the registered range
+	// 1024-49151, below the dynamic range.
+	PortClassRegistered
+)
+
+var (
+	// probeStrategy is the order in which candidate blocks are tried.
+	probeStrategy = ProbeRandom
+
+	// seed seeds seededRand if seedSet is true.
+	seed    int64
+	seedSet bool
+
+	// blockAlignment is the multiple that the first port of the block is
+	// aligned to.
+	blockAlignment = 1
+
+	// blockCount is the number of port blocks reserved at initialization.
+	blockCount = 1
+
+	// reinitOnEmpty makes takes reserve a new block once every port of the
+	// current one has been stolen.
+	reinitOnEmpty bool
+
+	// allowPredicate restricts the ports of the block to those it accepts.
+	// Nil allows all ports.
+	allowPredicate func(port int) bool
+
+	// portClass is the IANA port range the block is placed in.
+	portClass = PortClassAny
+
+	// minPort is the lowest port the block may start at if it is above
+	// lowPort.
+	minPort int
+
+	// sortedResults makes Take return ports in ascending order.
+	sortedResults bool
+
+	// autoReclaim makes the background goroutine return stolen ports to
+	// circulation once they can be bound again.
+	autoReclaim bool
+
+	// holdDuringTake makes Take keep a listener open on each port it hands
+	// out until ReleaseHold is called.
+	holdDuringTake bool
+
+	// onInit is called at the end of every initialization.
+	onInit func(base, size int)
+
+	// avoidPorts replaces DefaultAvoidPorts if avoidPortsSet is true.
+	avoidPorts    []int
+	avoidPortsSet bool
+
+	// warmProbeCount is the number of ports verified during initialization,
+	// or negative to verify all of them.
+	warmProbeCount = -1
+
+	// reserveFree is the number of free ports that only TakeReserved may use.
+	reserveFree int
+
+	// portsPerWorker derives blockSize from GOMAXPROCS if positive.
+	portsPerWorker int
+
+	// validateReturns makes Return log the ports it cannot accept.
+	validateReturns bool
+
+	// allowPartialBlock and minUsablePorts configure how initialize treats a
+	// port block where some ports are already in use.
+	allowPartialBlock bool
+	minUsablePorts    = 1
+
+	// theftBlacklistThreshold is the number of thefts after which a port is
+	// blacklisted. Zero disables blacklisting.
+	theftBlacklistThreshold int
+
+	// theftBreakerThreshold is the fraction of stolen candidates at which
+	// the theft circuit breaker trips. Zero disables the breaker.
+	theftBreakerThreshold float64
+
+	// requireConsecutivePairs makes TakePair fail rather than fall back to
+	// arbitrary pairs.
+	requireConsecutivePairs bool
+
+	// reverifyInterval is how often the background goroutine processes the
+	// pending ports.
+	reverifyInterval = defaultReverifyInterval
+
+	// reverifyIntervalChanged tells the background goroutine to pick up a new
+	// reverifyInterval right away.
+	reverifyIntervalChanged = make(chan struct{}, 1)
+
+	// maxProbeAttempts bounds the number of candidate blocks tried during
+	// initialization. Zero means no bound.
+	maxProbeAttempts int
+
+	// workerBorrowing lets TakeForWorker take ports outside of the band of
+	// the worker.
+	workerBorrowing bool
+
+	// fifoTakes makes takes complete strictly in arrival order.
+	fifoTakes bool
+
+	// dialConfirm makes returned ports stay pending until a dial to them is
+	// refused.
+	dialConfirm bool
+
+	// maxWaiters is the number of callers that may wait for ports at once.
+	// Zero means unlimited.
+	maxWaiters int
+
+	// verifier replaces the built-in check of whether a candidate port is
+	// free. See SetVerifier.
+	verifier func(port int) error
+
+	// strictVerify makes verification also wait for stray inbound
+	// connections. It is read without holding mu.
+	strictVerify atomic.Bool
+
+	// stabilityCheck is how long verification waits before binding a port a
+	// second time, or 0 to bind once. It is read without holding mu.
+	stabilityCheck atomic.Int64
+
+	// portFormatter renders port numbers in log output. It holds a
+	// func(int) string, or nil for plain decimal, and is read without
+	// holding mu because log lines are formatted while mu is held.
+	portFormatter atomic.Pointer[func(port int) string]
+)
+
+const (
+	// minParallelBlockSize and maxParallelBlockSize bound the block size
+	// derived by SetBlockSizeFromParallelism.
+	minParallelBlockSize = 128
+	maxParallelBlockSize = 8192
+)
+
+// DefaultAvoidPorts are well-known ports of services commonly run on developer
+// machines (databases, message brokers, monitoring, ...). They are excluded
+// from the port block by default when they fall inside it.
+var DefaultAvoidPorts = []int{
+	2181,  // ZooKeeper
+	3000,  // Grafana and many dev servers
+	3306,  // MySQL
+	4222,  // NATS
+	5432,  // PostgreSQL
+	5672,  // RabbitMQ
+	6379,  // Redis
+	8080,  // HTTP alternate
+	8443,  // HTTPS alternate
+	8500,  // Consul
+	9000,  // MinIO and many dev servers
+	9042,  // Cassandra
+	9090,  // Prometheus
+	9092,  // Kafka
+	9200,  // Elasticsearch
+	11211, // Memcached
+	15672, // RabbitMQ management
+	26257, // CockroachDB
+	27017, // MongoDB
+}
+
+// SetProbeStrategy sets the order in which candidate port blocks are tried.
+// It must be called before the first port is taken.
+func SetProbeStrategy(s ProbeStrategy) {
+	mu.Lock()
+	defer mu.Unlock()
+	probeStrategy = s
+}
+
+// SetSeed seeds the random generator used to place the port block, making
+// ProbeRandom reproducible. It must be called before the first port is taken.
+func SetSeed(s int64) {
+	mu.Lock()
+	defer mu.Unlock()
+	seed, seedSet = s, true
+}
+
+// SetBlockAlignment aligns the first port of the reserved block to a multiple
+// of align, which simplifies "base + offset" style port schemes. Values below
+// 2 disable alignment. It must be called before the first port is taken.
+func SetBlockAlignment(align int) {
+	mu.Lock()
+	defer mu.Unlock()
+	blockAlignment = align
+}
+
+// SetBlockCount makes freeport reserve k disjoint port blocks instead of one
+// and hand out ports from all of them, for test suites that need more ports at
+// once than a single block provides. Each block is probed for like the first
+// one. Initialization panics if k blocks do not fit in the usable port space.
+// The blocks other than the first are not used by TakeForWorker bands, not
+// shared by ExportState and not reserved when CL_FREEPORT_RANGE is set. It
+// must be called before the first port is taken.
+func SetBlockCount(k int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if k < 1 {
+		k = 1
+	}
+	blockCount = k
+}
+
+// SetReinitOnEmpty makes a take that finds every port of the block stolen,
+// which would otherwise fail with ErrExhausted until the process restarts,
+// give up the block and probe for a new one, avoiding the blocks given up
+// before and the ephemeral port range like the initial probe. If no new block
+// can be reserved, e.g. on a host that is genuinely saturated or once
+// SetMaxProbeAttempts is reached, the take fails with ErrExhausted. Blocks
+// configured with CL_FREEPORT_RANGE are never given up.
+func SetReinitOnEmpty(enabled bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	reinitOnEmpty = enabled
+}
+
+// SetAllowPredicate restricts freeport to the ports for which allow returns
+// true, e.g. to match the ports a host firewall accepts. Candidate blocks in
+// which allow accepts no port at all are skipped while probing, and the ports
+// allow rejects are left out of the pool. If too few ports are left, takes
+// fail with ErrBlockTooSmall and the number of rejected ports. allow is called
+// with internal locks held and must not call into freeport. Passing nil allows
+// all ports. It must be called before the first port is taken.
+func SetAllowPredicate(allow func(port int) bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	allowPredicate = allow
+}
+
+// SetPortClass restricts the port block to the IANA port range of class, e.g.
+// PortClassDynamic to keep test traffic off registered service ports that
+// security tooling watches. SetMinPort still applies within the class.
+//
+// The dynamic range often is the ephemeral port range, or contains most of it,
+// e.g. 49152-65535 on macOS and Windows and 32768-60999 on Linux. With
+// PortClassDynamic the block is placed in the part of the dynamic range above
+// the ephemeral range if a block fits there; otherwise it is placed inside the
+// ephemeral range, where outgoing connections may steal its ports, and a
+// warning is logged. A CL_FREEPORT_RANGE outside of class is ignored. It must
+// be called before the first port is taken.
+func SetPortClass(class PortClass) {
+	mu.Lock()
+	defer mu.Unlock()
+	portClass = class
+}
+
+// SetMinPort makes freeport never probe or hand out ports below port, e.g. for
+// tools that reject low port numbers. Floors at or below the default of 10000
+// have no effect. If no block fits between the floor and the ephemeral port
+// range, e.g. because the floor is inside it, the block is placed above the
+// ephemeral range. Initialization panics if no block fits between the floor and
+// 65535, and a CL_FREEPORT_RANGE starting below the floor is ignored. It must
+// be called before the first port is taken.
+func SetMinPort(port int) {
+	mu.Lock()
+	defer mu.Unlock()
+	minPort = port
+}
+
+// SetSortedResults makes Take and its variants return ports in ascending order
+// instead of the order they come off the free list, e.g. for stable golden
+// files.
+func SetSortedResults(sorted bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	sortedResults = sorted
+}
+
+// sortResultsLocked sorts ports if SetSortedResults is enabled. It must be
+// called with mu held.
+func sortResultsLocked(ports []int) {
+	if sortedResults {
+		sort.Ints(ports)
+	}
+}
+
+// SetAutoReclaim makes freeport periodically re-check ports that were removed
+// from circulation because they were stolen, and return each one to the free
+// list once it can be bound again. This lets the pool recover from transient
+// theft, including the case where every port was stolen.
+func SetAutoReclaim(enabled bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	autoReclaim = enabled
+}
+
+// SetHoldDuringTake makes Take keep a listener open on every port it hands
+// out, so that nothing else can grab the port between Take and the moment the
+// caller binds it. The caller must call ReleaseHold right before binding the
+// port; ListenerFor, Reserve and ServeOnFree do so themselves. Returning a port
+// releases its hold as well.
+func SetHoldDuringTake(enabled bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	holdDuringTake = enabled
+}
+
+// OnInit registers fn to be called once freeport has reserved its port block,
+// with the first port and size of the block. It is called again if the block
+// is reserved anew after the package state is reset. fn is called with
+// freeport's internal lock held and must not call back into freeport. Passing
+// nil removes the callback.
+func OnInit(fn func(base, size int)) {
+	mu.Lock()
+	defer mu.Unlock()
+	onInit = fn
+}
+
+// SetAvoidPorts replaces DefaultAvoidPorts as the set of ports excluded from
+// the port block. Passing nil excludes nothing. It must be called before the
+// first port is taken.
+func SetAvoidPorts(ports []int) {
+	mu.Lock()
+	defer mu.Unlock()
+	avoidPorts = append([]int(nil), ports...)
+	avoidPortsSet = true
+}
+
+// avoidSetLocked returns the ports to exclude from the port block. It must be
+// called with mu held.
+func avoidSetLocked() map[int]struct{} {
+	ports := DefaultAvoidPorts
+	if avoidPortsSet {
+		ports = avoidPorts
+	}
+	set := make(map[int]struct{}, len(ports))
+	for _, port := range ports {
+		set[port] = struct{}{}
+	}
+	return set
+}
+
+// SetWarmProbeCount sets how many ports of the block are verified to be free
+// during initialization. The remaining ports are only verified when they are
+// taken, which makes initialization faster for large blocks at the expense of
+// slower takes. A negative value, the default, verifies the whole block up
+// front. It must be called before the first port is taken.
+func SetWarmProbeCount(n int) {
+	mu.Lock()
+	defer mu.Unlock()
+	warmProbeCount = n
+}
+
+// SetReserveFree keeps n free ports in reserve for TakeReserved. Take and its
+// variants treat the reserve as unavailable and wait for ports to be returned
+// rather than dip into it.
+func SetReserveFree(n int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	reserveFree = n
+	// waiters may now be able to proceed
+	wakeAllLocked()
+}
+
+// SetBlockSizeFromParallelism sizes the port block as GOMAXPROCS times
+// perWorker instead of using the fixed default, clamped to a minimum of 128
+// and a maximum of 8192 ports. The CL_RESERVE_PORTS environment variable still
+// takes precedence. Passing 0 restores the fixed default. It must be called
+// before the first port is taken.
+func SetBlockSizeFromParallelism(perWorker int) {
+	mu.Lock()
+	defer mu.Unlock()
+	portsPerWorker = perWorker
+}
+
+func blockSizeForParallelism(procs, perWorker int) int {
+	size := procs * perWorker
+	if size < minParallelBlockSize {
+		return minParallelBlockSize
+	}
+	if size > maxParallelBlockSize {
+		return maxParallelBlockSize
+	}
+	return size
+}
+
+// SetValidateReturns makes Return log a warning describing the ports it is
+// passed that are outside of the reserved port block or are not currently
+// taken, instead of ignoring them quietly, to help find callers that return
+// the wrong ports. Return never fails, since returning a port twice is a
+// common cleanup pattern; use ReturnErr to get such ports reported as an
+// error.
+func SetValidateReturns(enabled bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	validateReturns = enabled
+}
+
+// SetStrictVerify makes freeport, after binding a port to verify that it is
+// free, also wait briefly for stray inbound connections and treat the port as
+// in use if one arrives. This adds a short wait to the verification of every
+// port that is handed out; the wait happens without freeport's internal lock
+// held, and the check is skipped when the pool is filled or returned ports are
+// re-verified. It is off by default.
+func SetStrictVerify(enabled bool) {
+	strictVerify.Store(enabled)
+}
+
+// SetStabilityCheck makes freeport, after verifying that a port is free,
+// wait d and bind it again, skipping the port if the second bind fails. This
+// weeds out ports that a background process only releases momentarily, at the
+// cost of adding d to the verification of every port that is handed out. The
+// check runs without freeport's internal lock held, so other callers are not
+// stalled by it, and is skipped when the pool is filled or returned ports are
+// re-verified. A d of 0, the default, disables the check.
+func SetStabilityCheck(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	stabilityCheck.Store(int64(d))
+}
+
+// SetVerifier replaces the check freeport runs on every candidate port before
+// handing it out. verify reports whether the port is usable; a rejected port
+// is removed from circulation the same way a stolen port is. verify is called
+// without any internal locks held and must clean up anything it opens before
+// returning. Passing nil restores the built-in TCP bind check.
+func SetVerifier(verify func(port int) bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	if verify == nil {
+		verifier = nil
+		return
+	}
+	verifier = func(port int) error {
+		if !verify(port) {
+			return fmt.Errorf("freeport: port %d rejected by verifier", port)
+		}
+		return nil
+	}
+}
+
+// SetVerifierForTesting is like SetVerifier but is meant for tests that
+// exercise how freeport and its callers handle stolen ports, e.g. by failing
+// verification of chosen ports, without leaking real sockets. A port is
+// rejected if verify returns false or an error. The previous verifier is
+// restored when t ends.
+func SetVerifierForTesting(t TestingT, verify func(port int) (bool, error)) {
+	t.Helper()
+
+	mu.Lock()
+	prev := verifier
+	verifier = func(port int) error {
+		ok, err := verify(port)
+		if err != nil {
+			return fmt.Errorf("freeport: verification of port %d failed: %w", port, err)
+		}
+		if !ok {
+			return fmt.Errorf("freeport: port %d rejected by verifier", port)
+		}
+		return nil
+	}
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		verifier = prev
+	})
+}
+
+// SetPortFormatter replaces how port numbers are rendered in freeport's log
+// output, for example as an offset from a service's base port. format is only
+// called when a log line mentioning a port is actually written. Passing nil
+// restores plain decimal.
+func SetPortFormatter(format func(port int) string) {
+	if format == nil {
+		portFormatter.Store(nil)
+		return
+	}
+	portFormatter.Store(&format)
+}
+
+// SetDialConfirm makes freeport, before it puts a returned port back into
+// circulation, also dial the port on each of the host's non-loopback IPv4
+// addresses with a short timeout and keep the port pending unless every
+// connection is refused. This catches servers that are still up after their
+// ports were returned but that the bind check on 127.0.0.1 misses because
+// they listen on one of those addresses only. A dial that times out is
+// ambiguous and also keeps the port pending.
+func SetDialConfirm(enabled bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	dialConfirm = enabled
+}
+
+// SetMaxWaiters limits how many callers may wait for ports at the same time.
+// Once n callers are waiting, a take that would have to wait fails right away
+// with ErrTooManyWaiters instead of queuing, which bounds the pile-up of
+// blocked goroutines when the pool is exhausted. Passing 0, the default, means
+// unlimited.
+func SetMaxWaiters(n int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	maxWaiters = n
+}
+
+// SetFIFOTakes makes concurrent takes complete strictly in the order in which
+// they were called: a caller is only handed ports once every caller that
+// arrived before it has been served, even if enough ports for the later caller
+// are already free. This makes port assignment reproducible for tests that
+// start services concurrently, at the cost of throughput. The order is that of
+// arrival at freeport, not of goroutine creation.
+func SetFIFOTakes(enabled bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	fifoTakes = enabled
+	wakeAllLocked()
+}
+
+// SetAllowPartialBlock makes initialization explicitly accept a port block in
+// which some ports are already in use by other processes, as is common on busy
+// shared hosts. The ports found in use are left out, the effective size of the
+// block is logged, and initialization fails with a clear error if fewer than
+// the minimum set with SetMinUsablePorts remain. It must be called before the
+// first port is taken.
+func SetAllowPartialBlock(enabled bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	allowPartialBlock = enabled
+}
+
+// SetMinUsablePorts sets the minimum number of usable ports a partial port
+// block must have, see SetAllowPartialBlock. Blacklisting a port that drops the
+// block below this minimum is logged as well, see SetTheftBlacklistThreshold.
+// The default is 1. It must be called before the first port is taken.
+func SetMinUsablePorts(n int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	minUsablePorts = n
+}
+
+// SetTheftBlacklistThreshold blacklists a port for the lifetime of the process
+// once it has been found stolen n times, so that a port that keeps getting
+// grabbed by another process is no longer reclaimed with SetAutoReclaim and
+// retried. Passing 0, the default, disables blacklisting.
+func SetTheftBlacklistThreshold(n int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	theftBlacklistThreshold = n
+}
+
+// SetTheftCircuitBreaker makes takes fail fast with ErrSaturated once at least
+// threshold, a fraction between 0 and 1, of the candidate ports verified
+// within 10 seconds turn out to be stolen, which is a sign that the host is
+// saturated or misconfigured and that compensating for the thefts is futile.
+// The rate is only judged after 10 candidates. The breaker resets after 30
+// seconds. Passing 0, the default, disables it.
+func SetTheftCircuitBreaker(threshold float64) {
+	mu.Lock()
+	defer mu.Unlock()
+	if threshold < 0 {
+		threshold = 0
+	}
+	theftBreakerThreshold = threshold
+	resetBreakerLocked()
+}
+
+// SetRequireConsecutivePairs makes TakePair fail if it cannot form pairs of
+// consecutive ports instead of falling back to pairs of arbitrary ports.
+func SetRequireConsecutivePairs(enabled bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	requireConsecutivePairs = enabled
+}
+
+// defaultReverifyInterval is the default for SetReverifyInterval.
+const defaultReverifyInterval = 250 * time.Millisecond
+
+// SetReverifyInterval sets how often the background goroutine wakes up to
+// verify the batch of ports returned since its last run and to expire leases.
+// Returned ports become free again within about one interval. A longer
+// interval smooths CPU usage when many ports are returned at once, a shorter
+// one makes returned ports available sooner. The default is 250ms; a
+// non-positive value restores it.
+func SetReverifyInterval(d time.Duration) {
+	mu.Lock()
+	if d <= 0 {
+		d = defaultReverifyInterval
+	}
+	reverifyInterval = d
+	mu.Unlock()
+
+	select {
+	case reverifyIntervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+func currentReverifyInterval() time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+	return reverifyInterval
+}
+
+// SetMaxProbeAttempts bounds the number of candidate port blocks initialization
+// tries to lock before giving up, so that it fails fast on a host where most
+// blocks are held by other processes instead of scanning the whole port range.
+// The failure reports the blocks that were tried. Passing 0, the default,
+// tries every candidate block. It must be called before the first port is
+// taken.
+func SetMaxProbeAttempts(n int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	maxProbeAttempts = n
+}
+
+// SetWorkerBorrowing lets TakeForWorker fall back to ports outside of the band
+// of the worker when the band is exhausted, instead of failing.
+func SetWorkerBorrowing(enabled bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	workerBorrowing = enabled
+}
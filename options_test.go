@@ -0,0 +1,571 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeStrategy(t *testing.T) {
+	defer reset()
+	defer SetProbeStrategy(ProbeRandom)
+	t.Setenv("CL_RESERVE_PORTS", "128")
+
+	t.Run("sequential", func(t *testing.T) {
+		reset()
+		SetProbeStrategy(ProbeSequential)
+		initialize()
+
+		assert.Equal(t, 0, (firstPort-lowPort)%blockSize)
+
+		// With the first block held, the next candidate is the following block.
+		next, ln := alloc()
+		defer ln.Close()
+		assert.Greater(t, next, firstPort)
+		assert.Equal(t, 0, (next-lowPort)%blockSize)
+	})
+
+	t.Run("random_with_seed", func(t *testing.T) {
+		defer func() { seedSet = false }()
+		SetProbeStrategy(ProbeRandom)
+		SetSeed(42)
+
+		reset()
+		initialize()
+		first := firstPort
+
+		reset()
+		initialize()
+		assert.Equal(t, first, firstPort)
+	})
+}
+
+func TestBlockAlignment(t *testing.T) {
+	defer reset()
+	defer SetBlockAlignment(1)
+	t.Setenv("CL_RESERVE_PORTS", "100")
+
+	reset()
+	SetBlockAlignment(256)
+	initialize()
+
+	assert.Equal(t, 0, firstPort%256)
+	assert.Equal(t, 100, blockSize)
+	for block := 0; block < effectiveMaxBlocks; block++ {
+		assert.Equal(t, 0, blockBase(block)%256)
+	}
+	if ephemeralPortMin > 0 {
+		assert.False(t, intervalOverlap(firstPort, firstPort+blockSize-1, ephemeralPortMin, ephemeralPortMax))
+	}
+}
+
+func TestSortedResults(t *testing.T) {
+	defer reset()
+	defer SetSortedResults(false)
+
+	// Churn the free list so that it is no longer in ascending order.
+	ports, err := Take(5)
+	assert.NoError(t, err)
+	Return([]int{ports[3], ports[1], ports[4], ports[0], ports[2]})
+	numTotal := waitForStatsReset(t)
+
+	SetSortedResults(true)
+	sorted, err := Take(numTotal)
+	assert.NoError(t, err)
+	defer Return(sorted)
+	assert.IsNonDecreasing(t, sorted)
+}
+
+func TestOnInit(t *testing.T) {
+	defer reset()
+	defer OnInit(nil)
+
+	type block struct{ base, size int }
+	var calls []block
+	OnInit(func(base, size int) { calls = append(calls, block{base, size}) })
+
+	for i := 0; i < 2; i++ {
+		ports, err := Take(2)
+		assert.NoError(t, err)
+		Return(ports)
+	}
+	if assert.Len(t, calls, 1) {
+		assert.Equal(t, block{firstPort, blockSize}, calls[0])
+	}
+
+	reset()
+	ports, err := Take(1)
+	assert.NoError(t, err)
+	Return(ports)
+	assert.Len(t, calls, 2)
+}
+
+func TestAvoidPorts(t *testing.T) {
+	defer reset()
+	defer func() {
+		avoidPorts, avoidPortsSet = nil, false
+	}()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30015")
+
+	reset()
+	SetAvoidPorts([]int{30003, 30007, 40000})
+	initialize()
+
+	free := peekAllFree()
+	assert.Len(t, free, 13)
+	assert.NotContains(t, free, 30003)
+	assert.NotContains(t, free, 30007)
+
+	reset()
+	SetAvoidPorts(nil)
+	initialize()
+	assert.Len(t, peekAllFree(), 15)
+}
+
+func TestWarmProbeCount(t *testing.T) {
+	defer reset()
+	defer SetWarmProbeCount(-1)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30015")
+
+	ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", 30001))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	reset()
+	SetWarmProbeCount(0)
+	once.Do(initialize)
+	assert.Len(t, peekAllFree(), 15, "expected unverified ports to be added lazily")
+
+	// The lazily added busy port is caught when it is taken.
+	ports, err := Take(1)
+	require.NoError(t, err)
+	assert.Equal(t, []int{30002}, ports)
+	numTotal, _, _ := stats()
+	assert.Equal(t, 14, numTotal)
+
+	reset()
+	SetWarmProbeCount(-1)
+	initialize()
+	assert.Len(t, peekAllFree(), 14)
+}
+
+func TestBlockSizeFromParallelism(t *testing.T) {
+	cases := []struct {
+		procs, perWorker, size int
+	}{
+		{1, 16, 128},      // minimum
+		{8, 64, 512},      // proportional
+		{256, 1024, 8192}, // maximum
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.size, blockSizeForParallelism(tc.procs, tc.perWorker), "procs=%d perWorker=%d", tc.procs, tc.perWorker)
+	}
+
+	defer reset()
+	defer SetBlockSizeFromParallelism(0)
+	t.Setenv("CL_RESERVE_PORTS", "")
+
+	reset()
+	SetBlockSizeFromParallelism(1)
+	initialize()
+	assert.Equal(t, blockSizeForParallelism(runtime.GOMAXPROCS(0), 1), blockSize)
+}
+
+func TestSetVerifier(t *testing.T) {
+	defer reset()
+	defer SetVerifier(nil)
+
+	var calls int
+	SetVerifier(func(port int) bool {
+		// The verifier must run without the package lock held.
+		if assert.True(t, mu.TryLock()) {
+			mu.Unlock()
+		}
+		calls++
+		return port%2 == 1
+	})
+
+	ports, err := Take(5)
+	require.NoError(t, err)
+	defer Return(ports)
+	for _, port := range ports {
+		assert.Equal(t, 1, port%2, "port %d should have been rejected", port)
+	}
+	assert.GreaterOrEqual(t, calls, 5)
+}
+
+func TestFIFOTakes(t *testing.T) {
+	defer reset()
+	defer SetFIFOTakes(false)
+	t.Setenv("CL_RESERVE_PORTS", "16")
+	SetFIFOTakes(true)
+
+	// Exhaust the pool so that every later take has to queue.
+	all, err := Take(15)
+	require.NoError(t, err)
+	PauseVerification()
+
+	type grant struct {
+		id    int
+		ports []int
+	}
+	grants := make(chan grant, 2)
+	take := func(id, n int) {
+		go func() {
+			ports, err := Take(n)
+			assert.NoError(t, err)
+			grants <- grant{id, ports}
+		}()
+	}
+	queued := func(n int) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return waiters.Len() == n
+		}
+	}
+
+	take(1, 2)
+	require.Eventually(t, queued(1), time.Second, time.Millisecond)
+	take(2, 1)
+	require.Eventually(t, queued(2), time.Second, time.Millisecond)
+
+	// A single free port would satisfy the second caller but must go to the
+	// first one, which arrived earlier.
+	release := func(port int) {
+		Return([]int{port})
+		ResumeVerification()
+		checkFreedPortsOnce()
+		PauseVerification()
+	}
+	release(all[0])
+	select {
+	case g := <-grants:
+		t.Fatalf("caller %d was granted %v out of order", g.id, g.ports)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release(all[1])
+	g := <-grants
+	assert.Equal(t, 1, g.id)
+	assert.ElementsMatch(t, all[:2], g.ports)
+
+	release(all[2])
+	g = <-grants
+	assert.Equal(t, 2, g.id)
+	assert.Equal(t, all[2:3], g.ports)
+
+	ResumeVerification()
+}
+
+func TestAllowPartialBlock(t *testing.T) {
+	defer reset()
+	defer SetAllowPartialBlock(false)
+	defer SetMinUsablePorts(1)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	var lns []net.Listener
+	defer func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}()
+	for port := 30001; port <= 30004; port++ {
+		ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", port))
+		require.NoError(t, err)
+		lns = append(lns, ln)
+	}
+
+	reset()
+	SetAllowPartialBlock(true)
+	SetMinUsablePorts(3)
+	once.Do(initialize)
+	assert.Len(t, peekAllFree(), 3)
+
+	reset()
+	SetMinUsablePorts(4)
+	assert.PanicsWithValue(t, "freeport: only 3 usable ports in the port block at 30000, need at least 4", func() {
+		once.Do(initialize)
+	})
+}
+
+func TestReverifyInterval(t *testing.T) {
+	defer reset()
+	defer SetReverifyInterval(0)
+
+	ports, err := Take(1)
+	require.NoError(t, err)
+	Return(ports)
+	waitForStatsReset(t)
+
+	SetReverifyInterval(time.Hour)
+	ports, err = Take(1000)
+	require.NoError(t, err)
+	Return(ports)
+	time.Sleep(500 * time.Millisecond)
+	_, numPending, _ := stats()
+	assert.Equal(t, 1000, numPending, "ports must not be verified before the interval elapses")
+
+	// Shortening the interval takes effect without waiting out the old one.
+	SetReverifyInterval(10 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, numPending, _ := stats()
+		return numPending == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMaxProbeAttempts(t *testing.T) {
+	defer reset()
+	defer SetProbeStrategy(ProbeRandom)
+	defer SetMaxProbeAttempts(0)
+	t.Setenv("CL_RESERVE_PORTS", "128")
+
+	// Find the first two free sequential blocks and hold them.
+	reset()
+	SetProbeStrategy(ProbeSequential)
+	initialize()
+	first := firstPort
+	second, secondLn := alloc()
+	defer secondLn.Close()
+	reset()
+	firstLn, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", first))
+	require.NoError(t, err)
+	defer firstLn.Close()
+
+	SetMaxProbeAttempts(2)
+	want := fmt.Sprintf("freeport: cannot allocate port block after 2 attempts; tried [%d, %d], [%d, %d]", first, first+127, second, second+127)
+	assert.PanicsWithValue(t, want, func() { once.Do(initialize) })
+}
+
+func TestSetPortFormatter(t *testing.T) {
+	defer SetPortFormatter(nil)
+
+	assert.Equal(t, "[30000 30002]", fmt.Sprintf("%v", logPorts{30000, 30002}))
+
+	called := 0
+	SetPortFormatter(func(port int) string {
+		called++
+		return fmt.Sprintf("base+%d", port-30000)
+	})
+	_ = logPorts{30000, 30002}
+	assert.Zero(t, called, "formatter must not run until the log line is formatted")
+
+	assert.Equal(t, "port base+1", fmt.Sprintf("port %v", logPort(30001)))
+	assert.Equal(t, "[base+0 base+2]", fmt.Sprintf("%v", logPorts{30000, 30002}))
+	assert.Equal(t, 3, called)
+
+	SetPortFormatter(nil)
+	assert.Equal(t, "30001", logPort(30001).String())
+}
+
+func TestSetMinPort(t *testing.T) {
+	defer reset()
+	defer SetMinPort(0)
+	t.Setenv("CL_RESERVE_PORTS", "128")
+
+	reset()
+	SetMinPort(20000)
+	once.Do(initialize)
+	assert.GreaterOrEqual(t, firstPort, 20000)
+
+	// A configured range below the floor is ignored.
+	reset()
+	t.Setenv("CL_FREEPORT_RANGE", "15000-15007")
+	once.Do(initialize)
+	assert.GreaterOrEqual(t, firstPort, 20000)
+	assert.Equal(t, 128, blockSize)
+
+	// A floor inside the ephemeral range moves the block above it, and fails
+	// clearly if there is no room there.
+	if min, max, guessed := EphemeralRange(); !guessed && min+1 > 10000 && max < 65535 {
+		reset()
+		t.Setenv("CL_FREEPORT_RANGE", "")
+		SetMinPort(min + 1)
+		if max+128 <= 65535 {
+			once.Do(initialize)
+			assert.Greater(t, firstPort, max)
+		} else {
+			assert.Panics(t, func() { once.Do(initialize) })
+		}
+
+		reset()
+		t.Setenv("CL_RESERVE_PORTS", strconv.Itoa(65535-max+1))
+		assert.PanicsWithValue(t, fmt.Sprintf("freeport: minimum port %d is in the ephemeral port range [%d, %d] and no block of %d ports fits above it", min+1, min, max, 65535-max+1), func() {
+			once.Do(initialize)
+		})
+		t.Setenv("CL_RESERVE_PORTS", "128")
+	}
+
+	reset()
+	t.Setenv("CL_FREEPORT_RANGE", "")
+	SetMinPort(65500)
+	assert.PanicsWithValue(t, "freeport: minimum port 65500 leaves no room for a block of 128 ports", func() {
+		once.Do(initialize)
+	})
+}
+
+func TestSetPortClass(t *testing.T) {
+	defer reset()
+	defer SetPortClass(PortClassAny)
+	t.Setenv("CL_RESERVE_PORTS", "128")
+
+	reset()
+	SetPortClass(PortClassDynamic)
+	once.Do(initialize)
+	assert.GreaterOrEqual(t, firstPort, 49152)
+	assert.LessOrEqual(t, firstPort+blockSize-1, 65535)
+	if min, max, guessed := EphemeralRange(); !guessed && max+128 <= 65535 {
+		assert.False(t, intervalOverlap(firstPort, firstPort+blockSize-1, min, max),
+			"block at %d overlaps the ephemeral port range [%d, %d]", firstPort, min, max)
+	}
+
+	// A configured range outside the class is ignored.
+	reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+	once.Do(initialize)
+	assert.GreaterOrEqual(t, firstPort, 49152)
+	assert.Equal(t, 128, blockSize)
+
+	reset()
+	t.Setenv("CL_FREEPORT_RANGE", "")
+	SetPortClass(PortClassRegistered)
+	SetProbeStrategy(ProbeSequential)
+	defer SetProbeStrategy(ProbeRandom)
+	once.Do(initialize)
+	assert.LessOrEqual(t, firstPort+blockSize-1, 49151)
+}
+
+func TestSetVerifierForTesting(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	ft := &fakeT{name: "partition"}
+	SetVerifierForTesting(ft, func(port int) (bool, error) {
+		if port == 30001 {
+			return false, errors.New("bind: permission denied")
+		}
+		return port != 30002, nil
+	})
+
+	ports, err := Take(2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{30003, 30004}, ports)
+	_, _, _, _, numThefts := Counters()
+	assert.Equal(t, uint64(2), numThefts)
+
+	ft.runCleanups()
+	mu.Lock()
+	assert.Nil(t, verifier)
+	mu.Unlock()
+
+	Return(ports)
+}
+
+func TestSetBlockCount(t *testing.T) {
+	defer reset()
+	defer SetBlockCount(1)
+	t.Setenv("CL_RESERVE_PORTS", "64")
+
+	reset()
+	SetBlockCount(3)
+	ports, err := Take(1)
+	require.NoError(t, err)
+
+	mu.Lock()
+	bases := append([]int{firstPort}, extraBlocks...)
+	mu.Unlock()
+	require.Len(t, bases, 3)
+	for i := range bases {
+		for j := i + 1; j < len(bases); j++ {
+			assert.False(t, intervalOverlap(bases[i], bases[i]+63, bases[j], bases[j]+63), "blocks %d and %d overlap", bases[i], bases[j])
+		}
+	}
+	numTotal, _, _ := stats()
+	assert.Greater(t, numTotal, 63, "expected ports from more than one block")
+
+	ranges := View().Ranges()
+	require.Len(t, ranges, 3)
+	for i, r := range ranges {
+		assert.Equal(t, PortRange{Base: bases[i], Count: 64}, r)
+	}
+	if overlap, msg := CheckEphemeralOverlap(); !overlap {
+		assert.Contains(t, msg, "port blocks ")
+	}
+
+	// Ports of every block can be taken and returned.
+	all, err := Take(numTotal - 1)
+	require.NoError(t, err)
+	assert.NoError(t, ReturnErr(all))
+	assert.ErrorContains(t, ReturnErr([]int{bases[0]}), "outside of the port blocks")
+	Return(ports)
+
+	reset()
+	SetBlockCount(100000)
+	assert.Panics(t, func() { once.Do(initialize) })
+}
+
+func TestSetMaxWaiters(t *testing.T) {
+	defer reset()
+	defer SetMaxWaiters(0)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	all, err := Take(7)
+	require.NoError(t, err)
+	SetMaxWaiters(1)
+
+	done := make(chan []int)
+	go func() {
+		ports, _ := Take(1)
+		done <- ports
+	}()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return waiters.Len() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = Take(1)
+	assert.True(t, errors.Is(err, ErrTooManyWaiters))
+	assert.EqualError(t, err, "freeport: too many waiters: 1 callers are already waiting for ports")
+
+	Return(all[:1])
+	Return(<-done)
+	Return(all[1:])
+}
+
+func TestSetAllowPredicate(t *testing.T) {
+	defer reset()
+	defer SetAllowPredicate(nil)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	SetAllowPredicate(func(port int) bool { return port%2 == 0 })
+
+	_, err := Take(4)
+	assert.True(t, errors.Is(err, ErrBlockTooSmall))
+	assert.EqualError(t, err, "freeport: block size too small: cannot take 4 ports (free=3, total=3, disallowed=4)")
+
+	ports, err := Take(3)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{30002, 30004, 30006}, ports)
+	Return(ports)
+
+	// Blocks without any allowed port are skipped while probing.
+	reset()
+	t.Setenv("CL_FREEPORT_RANGE", "")
+	t.Setenv("CL_RESERVE_PORTS", "64")
+	SetProbeStrategy(ProbeSequential)
+	defer SetProbeStrategy(ProbeRandom)
+	SetAllowPredicate(func(port int) bool { return port >= blockBase(3) })
+	once.Do(initialize)
+	assert.GreaterOrEqual(t, firstPort, blockBase(3))
+}
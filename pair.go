@@ -0,0 +1,64 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "fmt"
+
+// TakePair returns n pairs of free ports, e.g. a control and a data port for
+// each instance of an FTP style protocol. Each pair is made of two consecutive
+// ports, the second being the first plus one, whenever the free ports allow.
+// Otherwise TakePair falls back to two arbitrary ports, or fails if
+// SetRequireConsecutivePairs is enabled. Return the ports of all pairs with
+// ReturnPairs. See Take for more details.
+func TakePair(n int) ([][2]int, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: cannot take %d port pairs", ErrInvalidCount, n)
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	pairs := make([][2]int, 0, n)
+	undo := func() {
+		for _, pair := range pairs {
+			putBackLocked(pair[1])
+			putBackLocked(pair[0])
+		}
+	}
+	for len(pairs) < n {
+		if r, err := takeContiguousLocked(2); err == nil {
+			pairs = append(pairs, [2]int{r.Base, r.Base + 1})
+			continue
+		} else if requireConsecutivePairs {
+			undo()
+			return nil, fmt.Errorf("freeport: cannot form consecutive port pair %d of %d: %w", len(pairs)+1, n, err)
+		}
+
+		ports, err := takeLocked(2)
+		if err != nil {
+			undo()
+			return nil, err
+		}
+		pairs = append(pairs, [2]int{ports[0], ports[1]})
+	}
+
+	var all []int
+	for _, pair := range pairs {
+		all = append(all, pair[0], pair[1])
+	}
+	countTake(all)
+	return pairs, nil
+}
+
+// ReturnPairs returns the ports of pairs taken with TakePair to the pool.
+func ReturnPairs(pairs [][2]int) {
+	ports := make([]int, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		ports = append(ports, pair[0], pair[1])
+	}
+	Return(ports)
+}
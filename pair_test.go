@@ -0,0 +1,43 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakePair(t *testing.T) {
+	defer reset()
+	defer SetRequireConsecutivePairs(false)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30008")
+
+	pairs, err := TakePair(2)
+	require.NoError(t, err)
+	for _, pair := range pairs {
+		assert.Equal(t, pair[0]+1, pair[1])
+	}
+
+	// Fragment the rest of the block so that only 30005 and 30007 stay free.
+	gaps, err := TakeDistinctFrom(2, []int{30005, 30007})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int{30006, 30008}, gaps)
+
+	SetRequireConsecutivePairs(true)
+	_, err = TakePair(1)
+	require.Error(t, err)
+	assert.Len(t, TakenPorts(), 6, "a failed TakePair must not keep any ports")
+
+	SetRequireConsecutivePairs(false)
+	more, err := TakePair(1)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{30005, 30007}, more[0][:])
+
+	ReturnPairs(pairs)
+	ReturnPairs(more)
+	Return(gaps)
+	assert.Empty(t, TakenPorts())
+}
@@ -0,0 +1,39 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "fmt"
+
+// TakeEven is like Take but only returns even port numbers, e.g. for RTP media
+// ports whose RTCP port is expected at port+1. Unlike Take it does not wait
+// for ports to be returned; it fails if too few free ports are even.
+func TakeEven(n int) ([]int, error) {
+	return takeParity(n, 0, "even")
+}
+
+// TakeOdd is like TakeEven but only returns odd port numbers.
+func TakeOdd(n int) ([]int, error) {
+	return takeParity(n, 1, "odd")
+}
+
+func takeParity(n, parity int, name string) ([]int, error) {
+	if n <= 0 {
+		return nil, invalidCountError(n)
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	ports, found := takeFilteredLocked(n, func(port int) bool { return port%2 == parity })
+	if ports == nil {
+		return nil, fmt.Errorf("freeport: cannot take %d %s ports; only %d free ports qualify", n, name, found)
+	}
+
+	sortResultsLocked(ports)
+	countTake(ports)
+	return ports, nil
+}
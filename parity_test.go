@@ -0,0 +1,34 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeParity(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	even, err := TakeEven(3)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{30002, 30004, 30006}, even)
+
+	_, err = TakeEven(1)
+	assert.EqualError(t, err, "freeport: cannot take 1 even ports; only 0 free ports qualify")
+
+	_, err = TakeOdd(5)
+	assert.EqualError(t, err, "freeport: cannot take 5 odd ports; only 4 free ports qualify")
+	assert.Len(t, peekAllFree(), 4, "a failed take must put its ports back")
+
+	odd, err := TakeOdd(4)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{30001, 30003, 30005, 30007}, odd)
+
+	Return(even)
+	Return(odd)
+}
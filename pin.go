@@ -0,0 +1,61 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "fmt"
+
+// Pin reserves a specific port of the block and keeps it out of the pool until
+// Unpin is called, e.g. for a service whose port must stay fixed while other
+// ports are taken and returned around it. Take never hands out a pinned port
+// and Return ignores it. Pinning a port that is free, or returned and awaiting
+// verification, succeeds; pinning a port that is taken by someone else, is
+// outside of the block or is in use by another process fails. Pinning a port
+// that is already pinned does nothing.
+func Pin(port int) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	if _, ok := pinned[port]; ok {
+		return nil
+	}
+	if _, ok := taken[port]; ok {
+		return fmt.Errorf("freeport: cannot pin port %d which is taken", port)
+	}
+
+	switch {
+	case removePort(freePorts, port):
+		// The plain bind check; the strict and stability checks would wait
+		// with mu held.
+		if err := probeBind(port, false, nil); err != nil {
+			stolenLocked(port)
+			return fmt.Errorf("freeport: cannot pin port %d which is in use: %w", port, err)
+		}
+	case removePort(pendingPorts, port):
+		// The caller takes over responsibility for the port being usable.
+	default:
+		return fmt.Errorf("freeport: cannot pin port %d which is not in the pool", port)
+	}
+
+	// The port was verified above or is the caller's responsibility now.
+	delete(unverified, port)
+	pinned[port] = struct{}{}
+	updateMaxUsedLocked()
+	return nil
+}
+
+// Unpin releases a port pinned with Pin back to the pool. The port is
+// re-verified before it is handed out again, like a returned port. Unpinning a
+// port that is not pinned does nothing.
+func Unpin(port int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := pinned[port]; !ok {
+		return
+	}
+	delete(pinned, port)
+	pendingPorts.PushBack(port)
+}
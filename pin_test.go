@@ -0,0 +1,71 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPin(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	require.NoError(t, Pin(30003))
+	require.NoError(t, Pin(30003))
+
+	ports, err := Take(6)
+	require.NoError(t, err)
+	assert.NotContains(t, ports, 30003)
+
+	// Returning the pinned port along with the rest does not unpin it.
+	Return(append(ports, 30003))
+	assert.NotContains(t, peekAllFree(), 30003)
+
+	assert.Error(t, Pin(30000), "the lock port is not part of the pool")
+	taken, err := Take(1)
+	require.NoError(t, err)
+	assert.Error(t, Pin(taken[0]), "taken ports cannot be pinned")
+	Return(taken)
+
+	Unpin(30003)
+	Unpin(30003)
+	assert.Equal(t, 7, waitForStatsReset(t))
+	assert.Contains(t, peekAllFree(), 30003)
+}
+
+func TestPinWarmUp(t *testing.T) {
+	defer reset()
+	defer SetWarmProbeCount(-1)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+	SetWarmProbeCount(0)
+
+	require.NoError(t, Pin(30003))
+	ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", 30003))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	require.NoError(t, WarmUpContext(context.Background(), nil))
+	numTotal, numPending, numFree := stats()
+	assert.Equal(t, 7, numTotal)
+	assert.Equal(t, 6, numFree+numPending)
+	_, _, _, _, numThefts := Counters()
+	assert.Zero(t, numThefts, "the pinned port is in use by its owner, not stolen")
+}
+
+func TestPinStabilityCheck(t *testing.T) {
+	defer reset()
+	defer SetStabilityCheck(0)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+	SetStabilityCheck(time.Second)
+
+	start := time.Now()
+	require.NoError(t, Pin(30003))
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "Pin must not wait out the stability check with the lock held")
+}
@@ -0,0 +1,84 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "fmt"
+
+var (
+	// quotas holds the limits set with SetQuota, keyed by identity.
+	quotas = make(map[string]int)
+
+	// defaultQuota is the limit for identities without an entry in quotas.
+	// 0 means unlimited.
+	defaultQuota int
+)
+
+// SetQuota limits how many ports identity may hold at once through TakeAs. A
+// max of 0 or less removes the limit for identity, which then falls back to
+// the default quota set with SetDefaultQuota.
+func SetQuota(identity string, max int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if max <= 0 {
+		delete(quotas, identity)
+		return
+	}
+	quotas[identity] = max
+}
+
+// SetDefaultQuota sets the limit used by TakeAs for identities without a quota
+// of their own. A max of 0, the default, means unlimited.
+func SetDefaultQuota(max int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if max < 0 {
+		max = 0
+	}
+	defaultQuota = max
+}
+
+// TakeAs is like Take but charges the ports to identity until they are
+// returned, and fails with ErrQuotaExceeded instead of taking any ports if
+// that would leave identity holding more than its quota. Ports a call is still
+// waiting for count against the quota too, so concurrent calls for the same
+// identity cannot overshoot it.
+func TakeAs(identity string, n int) ([]int, error) {
+	if n <= 0 {
+		return nil, invalidCountError(n)
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	max, ok := quotas[identity]
+	if !ok {
+		max = defaultQuota
+	}
+	if max > 0 && quotaUsed[identity]+n > max {
+		return nil, fmt.Errorf("%w for %s", ErrQuotaExceeded, identity)
+	}
+
+	quotaUsed[identity] += n
+	ports, err := takeLocked(n)
+	if err != nil {
+		releaseQuotaLocked(identity, n)
+		return nil, err
+	}
+	for _, port := range ports {
+		owners[port] = identity
+	}
+	countTake(ports)
+	return ports, nil
+}
+
+// releaseQuotaLocked stops charging n ports to identity. It must be called
+// with mu held.
+func releaseQuotaLocked(identity string, n int) {
+	if quotaUsed[identity] -= n; quotaUsed[identity] <= 0 {
+		delete(quotaUsed, identity)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeAs(t *testing.T) {
+	defer reset()
+	defer SetDefaultQuota(0)
+	defer SetQuota("noisy", 0)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	SetQuota("noisy", 3)
+	SetDefaultQuota(1)
+
+	first, err := TakeAs("noisy", 2)
+	require.NoError(t, err)
+	second, err := TakeAs("noisy", 1)
+	require.NoError(t, err)
+
+	_, err = TakeAs("noisy", 1)
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+	assert.EqualError(t, err, "freeport: quota exceeded for noisy")
+
+	// Other identities fall back to the default quota.
+	other, err := TakeAs("quiet", 1)
+	require.NoError(t, err)
+	_, err = TakeAs("quiet", 1)
+	assert.EqualError(t, err, "freeport: quota exceeded for quiet")
+
+	// Returned ports no longer count against the quota.
+	Return(first)
+	again, err := TakeAs("noisy", 2)
+	require.NoError(t, err)
+
+	// A failed take doesn't keep the ports it asked for charged.
+	SetQuota("big", 100)
+	_, err = TakeAs("big", 20)
+	assert.True(t, errors.Is(err, ErrBlockTooSmall))
+	mu.Lock()
+	assert.Zero(t, quotaUsed["big"])
+	mu.Unlock()
+	SetQuota("big", 0)
+
+	Return(second)
+	Return(other)
+	Return(again)
+	mu.Lock()
+	assert.Empty(t, quotaUsed)
+	assert.Empty(t, owners)
+	mu.Unlock()
+}
@@ -0,0 +1,49 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"os"
+	"strings"
+)
+
+// foreignRangesFromEnv parses the FREEPORT_RESERVED_RANGES environment
+// variable, a comma-separated list of "min-max" port ranges that other
+// freeport-based tools on this host have reserved, e.g. the block used by
+// Consul's or Nomad's test binaries. Malformed entries are skipped.
+func foreignRangesFromEnv() [][2]int {
+	env := os.Getenv("FREEPORT_RESERVED_RANGES")
+	if env == "" {
+		return nil
+	}
+
+	var ranges [][2]int
+	for _, entry := range strings.Split(env, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		min, max, err := parseRange(entry)
+		if err != nil {
+			logf("WARN", "skipping invalid FREEPORT_RESERVED_RANGES entry %q", entry)
+			continue
+		}
+		ranges = append(ranges, [2]int{min, max})
+	}
+	if len(ranges) > 0 {
+		logf("INFO", "avoiding %d port ranges from FREEPORT_RESERVED_RANGES environment variable", len(ranges))
+	}
+	return ranges
+}
+
+// overlapsForeignRange reports whether the block of size ports starting at
+// base overlaps any of the ranges reserved by other tools. It must be called
+// with mu held.
+func overlapsForeignRange(base, size int) bool {
+	for _, r := range foreignRanges {
+		if intervalOverlap(base, base+size-1, r[0], r[1]) {
+			return true
+		}
+	}
+	return false
+}
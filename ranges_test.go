@@ -0,0 +1,39 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForeignRanges(t *testing.T) {
+	defer reset()
+	defer SetProbeStrategy(ProbeRandom)
+	t.Setenv("CL_RESERVE_PORTS", "128")
+
+	t.Run("parse", func(t *testing.T) {
+		t.Setenv("FREEPORT_RESERVED_RANGES", "10000-10999, bogus,20000-19000,,30000-30127")
+		assert.Equal(t, [][2]int{{10000, 10999}, {30000, 30127}}, foreignRangesFromEnv())
+	})
+
+	t.Run("avoided", func(t *testing.T) {
+		// Reserve the first few sequential blocks on behalf of another tool.
+		reset()
+		SetProbeStrategy(ProbeSequential)
+		mu.Lock()
+		once.Do(initialize)
+		first := firstPort
+		mu.Unlock()
+
+		reset()
+		t.Setenv("FREEPORT_RESERVED_RANGES", fmt.Sprintf("%d-%d", first, first+3*128-1))
+		mu.Lock()
+		once.Do(initialize)
+		assert.False(t, intervalOverlap(firstPort, firstPort+blockSize-1, first, first+3*128-1))
+		mu.Unlock()
+	})
+}
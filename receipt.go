@@ -0,0 +1,83 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Allocation is a receipt for ports taken with TakeReceipt. It can be
+// serialized to JSON for logging, and its ID correlates the log lines of the
+// take and the return.
+type Allocation struct {
+	ID      string    `json:"id"`
+	Ports   []int     `json:"ports"`
+	TakenAt time.Time `json:"taken_at"`
+	Label   string    `json:"label,omitempty"`
+}
+
+// TakeReceipt is like TakeLabeled but returns an Allocation describing the
+// ports taken. Return the ports with ReturnReceipt.
+func TakeReceipt(n int, label string) (Allocation, error) {
+	if n <= 0 {
+		return Allocation{}, invalidCountError(n)
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return Allocation{}, err
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	ports, err := takeLocked(n)
+	if err != nil {
+		return Allocation{}, err
+	}
+	for _, port := range ports {
+		portLastUser[port] = label
+	}
+	countTake(ports)
+
+	a := Allocation{
+		ID:      hex.EncodeToString(id[:]),
+		Ports:   ports,
+		TakenAt: time.Now(),
+		Label:   label,
+	}
+	for _, port := range ports {
+		receipts[port] = a.ID
+	}
+	logf("DEBUG", "allocation %s took ports %v", a.ID, logPorts(a.Ports))
+	return a, nil
+}
+
+// ReturnReceipt returns the ports of an allocation made with TakeReceipt to
+// the pool. Ports of the allocation that were already returned, e.g. with
+// Return, are skipped even if someone else has taken them since; returning the
+// same allocation again only logs a warning.
+func ReturnReceipt(a Allocation) {
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	var owned []int
+	for _, port := range a.Ports {
+		if id, ok := receipts[port]; ok && id == a.ID {
+			owned = append(owned, port)
+		}
+	}
+	if len(owned) == 0 {
+		logf("WARN", "allocation %s with ports %v was already returned", a.ID, logPorts(a.Ports))
+		return
+	}
+	returnLocked(owned, ReasonUnspecified)
+	logf("DEBUG", "allocation %s returned ports %v", a.ID, logPorts(owned))
+}
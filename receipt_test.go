@@ -0,0 +1,63 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeReceipt(t *testing.T) {
+	defer reset()
+
+	a, err := TakeReceipt(2, "db")
+	require.NoError(t, err)
+	b, err := TakeReceipt(1, "api")
+	require.NoError(t, err)
+	assert.NotEqual(t, a.ID, b.ID)
+	assert.Len(t, a.Ports, 2)
+	assert.Equal(t, "db", a.Label)
+
+	out, err := json.Marshal(a)
+	require.NoError(t, err)
+	var decoded Allocation
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, a.ID, decoded.ID)
+	assert.Equal(t, a.Ports, decoded.Ports)
+	assert.True(t, a.TakenAt.Equal(decoded.TakenAt))
+
+	// The decoded receipt returns the ports just as well, but only once.
+	ReturnReceipt(decoded)
+	ReturnReceipt(a)
+	assert.Equal(t, b.Ports, TakenPorts())
+
+	ReturnReceipt(b)
+	assert.Empty(t, TakenPorts())
+}
+
+func TestReturnReceiptAfterReturn(t *testing.T) {
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30003")
+	defer reset()
+
+	a, err := TakeReceipt(2, "db")
+	require.NoError(t, err)
+	Return(a.Ports[:1])
+	assert.Eventually(t, func() bool {
+		_, numPending, _ := stats()
+		return numPending == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Someone else takes the port returned outside of the receipt.
+	others, err := Take(2)
+	require.NoError(t, err)
+	require.Contains(t, others, a.Ports[0])
+
+	ReturnReceipt(a)
+	assert.ElementsMatch(t, others, TakenPorts())
+	Return(others)
+}
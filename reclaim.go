@@ -0,0 +1,33 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+// reclaimLostPorts returns stolen ports that are no longer in use to the free
+// list if SetAutoReclaim is enabled.
+func reclaimLostPorts() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !autoReclaim || len(lostPorts) == 0 {
+		return
+	}
+
+	reclaimed := 0
+	for port := range lostPorts {
+		if used := isPortInUse(port); used {
+			continue
+		}
+		delete(lostPorts, port)
+		freePorts.PushBack(port)
+		total++
+		reclaimed++
+	}
+
+	if reclaimed == 0 {
+		return
+	}
+
+	logf("INFO", "reclaimed %d stolen ports; %d still lost", reclaimed, len(lostPorts))
+	wakeSatisfiableLocked()
+}
@@ -0,0 +1,92 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoReclaim(t *testing.T) {
+	defer reset()
+	defer SetAutoReclaim(false)
+	SetAutoReclaim(true)
+
+	ports, err := Take(1)
+	require.NoError(t, err)
+	Return(ports)
+	numTotal := waitForStatsReset(t)
+
+	// Steal every port so that the block drops to zero.
+	leaked := make([]io.Closer, 0, numTotal)
+	defer func() {
+		for _, c := range leaked {
+			c.Close()
+		}
+	}()
+	for _, port := range peekAllFree() {
+		ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", port))
+		require.NoError(t, err)
+		leaked = append(leaked, ln)
+	}
+
+	_, err = Take(numTotal)
+	require.Error(t, err)
+	_, err = Take(1)
+	require.Error(t, err)
+
+	// Once the thieves let go the pool recovers.
+	for _, c := range leaked {
+		c.Close()
+	}
+	leaked = nil
+	assert.Eventually(t, func() bool {
+		newTotal, _, _ := stats()
+		return newTotal == numTotal
+	}, 5*time.Second, 100*time.Millisecond)
+
+	ports, err = Take(1)
+	require.NoError(t, err)
+	Return(ports)
+}
+
+func TestTheftBlacklist(t *testing.T) {
+	defer reset()
+	defer SetAutoReclaim(false)
+	defer SetTheftBlacklistThreshold(0)
+	SetAutoReclaim(true)
+	SetTheftBlacklistThreshold(2)
+
+	ports, err := Take(1)
+	require.NoError(t, err)
+	Return(ports)
+	numTotal := waitForStatsReset(t)
+
+	port := peekAllFree()[0]
+	steal := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		removePort(freePorts, port)
+		stolenLocked(port)
+	}
+
+	// The first theft is forgiven once the port is free again.
+	steal()
+	reclaimLostPorts()
+	assert.Contains(t, peekAllFree(), port)
+	assert.Empty(t, Blacklisted())
+
+	// The second one is not.
+	steal()
+	reclaimLostPorts()
+	assert.NotContains(t, peekAllFree(), port)
+	assert.Equal(t, []int{port}, Blacklisted())
+	newTotal, _, _ := stats()
+	assert.Equal(t, numTotal-1, newTotal)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "fmt"
+
+// abandonedBlocks are the first ports of blocks given up by
+// reinitOnEmptyLocked after all of their ports were stolen. They are not
+// probed again. It is guarded by mu.
+var abandonedBlocks []int
+
+// abandonedLocked reports whether the block starting at base has been given
+// up. It must be called with mu held.
+func abandonedLocked(base int) bool {
+	for _, b := range abandonedBlocks {
+		if b == base {
+			return true
+		}
+	}
+	return false
+}
+
+// canReinitLocked reports whether a take that found the pool empty should
+// reserve a new block, see SetReinitOnEmpty. It must be called with mu held.
+func canReinitLocked() bool {
+	return reinitOnEmpty && !fixedBlock && outstandingLocked() == 0
+}
+
+// reinitOnEmptyLocked gives up the current blocks, all of whose ports have
+// been stolen, and reserves and fills new ones in their place. The pool is
+// rebuilt in place so that callers waiting for ports are not disturbed. If no
+// new block can be reserved, it returns an ErrExhausted error for a take of n
+// ports. It must be called with mu held.
+func reinitOnEmptyLocked(n int) (err error) {
+	logf("WARN", "all ports of the port block at %d were stolen; reserving a new block", firstPort)
+	abandonedBlocks = append(abandonedBlocks, firstPort)
+	abandonedBlocks = append(abandonedBlocks, extraBlocks...)
+	releaseListenersLocked()
+	extraBlocks = nil
+
+	// The bookkeeping of stolen ports refers to the old blocks; keep it from
+	// reclaiming them into the new pool.
+	lostPorts = make(map[int]struct{})
+	theftCounts = make(map[int]int)
+	blacklist = make(map[int]struct{})
+	unverified = make(map[int]struct{})
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: cannot take %d ports; all ports of the block were stolen and no new block could be reserved: %v", ErrExhausted, n, r)
+		}
+	}()
+	firstPort, lockLn = probeBlock()
+	allocExtraBlocks()
+	fillPoolLocked()
+	logf("INFO", "reserved new port block [%d, %d]", firstPort, firstPort+blockSize-1)
+
+	wakeAllLocked()
+	if onInit != nil {
+		onInit(firstPort, blockSize)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReinitOnEmpty(t *testing.T) {
+	t.Setenv("CL_RESERVE_PORTS", "16")
+	defer reset()
+	defer SetReinitOnEmpty(false)
+
+	stealBlock := func() (int, *fakeT) {
+		mu.Lock()
+		once.Do(initialize)
+		base := firstPort
+		mu.Unlock()
+		ft := &fakeT{name: "stolen"}
+		SetVerifierForTesting(ft, func(port int) (bool, error) {
+			return port < base || port >= base+blockSize, nil
+		})
+		return base, ft
+	}
+
+	_, ft := stealBlock()
+	_, err := Take(1)
+	assert.True(t, errors.Is(err, ErrExhausted), "got %v", err)
+	ft.runCleanups()
+	reset()
+
+	SetReinitOnEmpty(true)
+	base, ft := stealBlock()
+	defer ft.runCleanups()
+	ports, err := Take(1)
+	require.NoError(t, err)
+	assert.True(t, ports[0] < base || ports[0] >= base+16, "port %d is in the stolen block at %d", ports[0], base)
+	Return(ports)
+}
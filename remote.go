@@ -0,0 +1,45 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "fmt"
+
+// TakeRemoteVerified is like Take but additionally requires every port to pass
+// verify, e.g. a callback that asks an agent on another host to bind the port.
+// Ports rejected by verify are skipped and replaced with other candidates, the
+// same way Take compensates for stolen ports; they are returned to the pool
+// once the call completes. verify is called without any internal locks held.
+func TakeRemoteVerified(n int, verify func(port int) error) ([]int, error) {
+	if n <= 0 {
+		return nil, invalidCountError(n)
+	}
+
+	var ports, rejected []int
+	defer func() { Return(rejected) }()
+
+	for len(ports) < n {
+		// Once candidates have been rejected, don't wait for other callers
+		// to return ports; the rejected ones may be all there is.
+		var candidates []int
+		var err error
+		if len(rejected) > 0 && Available() < n-len(ports) {
+			err = fmt.Errorf("freeport: not enough free ports left")
+		} else {
+			candidates, err = Take(n - len(ports))
+		}
+		if err != nil {
+			Return(ports)
+			return nil, fmt.Errorf("freeport: %d of %d ports verified, %d rejected remotely: %w", len(ports), n, len(rejected), err)
+		}
+		for _, port := range candidates {
+			if err := verify(port); err != nil {
+				logf("WARN", "port %v rejected by remote verification: %v", logPort(port), err)
+				rejected = append(rejected, port)
+				continue
+			}
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
@@ -0,0 +1,43 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeRemoteVerified(t *testing.T) {
+	defer reset()
+
+	// Reject every other candidate.
+	var seen []int
+	verify := func(port int) error {
+		seen = append(seen, port)
+		if len(seen)%2 == 1 {
+			return errors.New("busy on remote")
+		}
+		return nil
+	}
+
+	ports, err := TakeRemoteVerified(3, verify)
+	require.NoError(t, err)
+	assert.Len(t, ports, 3)
+	assert.Len(t, seen, 6)
+	for i, port := range seen {
+		if i%2 == 0 {
+			assert.NotContains(t, ports, port)
+		}
+	}
+	// rejected candidates go back to the pool
+	assert.ElementsMatch(t, ports, TakenPorts())
+	Return(ports)
+
+	_, err = TakeRemoteVerified(1, func(int) error { return errors.New("remote down") })
+	require.Error(t, err)
+	assert.Empty(t, TakenPorts())
+}
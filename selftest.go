@@ -0,0 +1,73 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SelfTest checks whether the environment is suitable for freeport, e.g. as a
+// pre-flight step in CI before any tests run. It initializes the package if
+// necessary, verifies that every free port of the block can be bound, checks
+// that the block does not overlap the ephemeral port range and that at least
+// SetMinUsablePorts ports are usable. It returns a descriptive error for every
+// problem found. Ports found in use are removed from circulation like stolen
+// ports; the pool is otherwise left as it was.
+func SelfTest() error {
+	mu.Lock()
+	if err := selfTestInitLocked(); err != nil {
+		mu.Unlock()
+		return err
+	}
+	busy, usable := 0, 0
+	for elem := freePorts.Front(); elem != nil; {
+		next := elem.Next()
+		port := elem.Value.(int)
+		delete(unverified, port)
+		if isPortInUse(port) {
+			freePorts.Remove(elem)
+			stolenLocked(port)
+			busy++
+		} else {
+			usable++
+		}
+		elem = next
+	}
+	base, size, min := firstPort, blockSize, minUsablePorts
+	mu.Unlock()
+
+	var errs []error
+	if busy > 0 {
+		errs = append(errs, fmt.Errorf("freeport: %d ports of the block [%d, %d] are in use by other processes", busy, base, base+size-1))
+	}
+	if usable < min {
+		errs = append(errs, fmt.Errorf("freeport: only %d ports of the block [%d, %d] are bindable, need at least %d", usable, base, base+size-1, min))
+	}
+	if overlap, desc := CheckEphemeralOverlap(); overlap {
+		errs = append(errs, errors.New(desc))
+	}
+	return errors.Join(errs...)
+}
+
+// selfTestInitLocked initializes the package if necessary and turns an
+// initialization panic into an error. The partial initialization is undone so
+// that the next caller tries again instead of using a pool that was never set
+// up. It must be called with mu held.
+func selfTestInitLocked() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			// The background goroutine is only started at the very end, but
+			// the onInit hook runs after it.
+			if stopCh != nil {
+				close(stopCh)
+				stopCh = nil
+			}
+			teardownLocked()
+			err = fmt.Errorf("freeport: initialization failed: %v", r)
+		}
+	}()
+	once.Do(initialize)
+	return nil
+}
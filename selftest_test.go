@@ -0,0 +1,47 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfTest(t *testing.T) {
+	defer reset()
+	defer SetMinUsablePorts(1)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	require.NoError(t, SelfTest())
+	assert.Len(t, peekAllFree(), 7, "SelfTest must leave the pool as it was")
+	assert.Empty(t, TakenPorts())
+
+	ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", 30003))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	SetMinUsablePorts(7)
+	err = SelfTest()
+	assert.ErrorContains(t, err, "1 ports of the block [30000, 30007] are in use")
+	assert.ErrorContains(t, err, "only 6 ports of the block [30000, 30007] are bindable, need at least 7")
+}
+
+func TestSelfTestInitFailure(t *testing.T) {
+	defer reset()
+	defer SetMinPort(0)
+	t.Setenv("CL_RESERVE_PORTS", "128")
+
+	SetMinPort(65500)
+	assert.EqualError(t, SelfTest(), "freeport: initialization failed: freeport: minimum port 65500 leaves no room for a block of 128 ports")
+
+	// The package is still usable and initializes again on the next call.
+	SetMinPort(0)
+	require.NoError(t, SelfTest())
+	ports, err := Take(1)
+	require.NoError(t, err)
+	Return(ports)
+}
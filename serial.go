@@ -0,0 +1,54 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"strings"
+	"sync"
+)
+
+var (
+	// serialMu guards serialHolder and serialDepth. It is independent of mu
+	// so that tests blocked in RequireSerial don't hold up the pool.
+	serialMu   sync.Mutex
+	serialCond = sync.NewCond(&serialMu)
+
+	// serialHolder is the name of the test that currently runs serially and
+	// serialDepth the number of RequireSerial calls made by it and its
+	// subtests that have not been cleaned up yet.
+	serialHolder string
+	serialDepth  int
+)
+
+// RequireSerial blocks until no other test that called RequireSerial is
+// running and then lets t run on its own until it ends. The pool is global to
+// the process, so tests that make assumptions about it, e.g. that they can
+// take every free port, must not overlap with other tests using freeport;
+// calling RequireSerial at the top of each such test serializes them even when
+// they are marked parallel. Subtests of a test that called RequireSerial may
+// call it too and do not block on their parent.
+func RequireSerial(t TestingT) {
+	t.Helper()
+	name := t.Name()
+
+	serialMu.Lock()
+	for serialHolder != "" && name != serialHolder && !strings.HasPrefix(name, serialHolder+"/") {
+		serialCond.Wait()
+	}
+	if serialHolder == "" {
+		serialHolder = name
+	}
+	serialDepth++
+	serialMu.Unlock()
+
+	t.Cleanup(func() {
+		serialMu.Lock()
+		defer serialMu.Unlock()
+		serialDepth--
+		if serialDepth == 0 {
+			serialHolder = ""
+			serialCond.Broadcast()
+		}
+	})
+}
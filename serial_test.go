@@ -0,0 +1,54 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireSerial(t *testing.T) {
+	parent := &fakeT{name: "TestA"}
+	RequireSerial(parent)
+
+	// Subtests of the holder don't block.
+	child := &fakeT{name: "TestA/sub"}
+	RequireSerial(child)
+
+	// Other tests wait until the holder and its subtests are done.
+	other := &fakeT{name: "TestAB"}
+	acquired := make(chan struct{})
+	go func() {
+		RequireSerial(other)
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("RequireSerial did not block while another test held it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	parent.runCleanups()
+	select {
+	case <-acquired:
+		t.Fatal("RequireSerial did not wait for the subtest")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	child.runCleanups()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("RequireSerial did not unblock")
+	}
+
+	other.runCleanups()
+	serialMu.Lock()
+	assert.Empty(t, serialHolder)
+	assert.Zero(t, serialDepth)
+	serialMu.Unlock()
+}
@@ -0,0 +1,102 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ServiceSpec describes the ports one service of a test topology needs, see
+// TakeServices.
+type ServiceSpec struct {
+	// Name identifies the service in the result of TakeServices.
+	Name string
+	// Count is the number of ports the service needs.
+	Count int
+	// Consecutive requires the ports of the service to be a single run of
+	// consecutive ports.
+	Consecutive bool
+}
+
+// TakeServices takes the ports for every service in specs at once and returns
+// them by service name. Either every service gets its ports or, if any of them
+// cannot be satisfied, no ports are taken and the error names the failing
+// service. The services that don't need consecutive ports are served first,
+// waiting for ports like Take if necessary. Services that need consecutive
+// ports are served last, so that no run of ports is held while waiting, and,
+// like TakeContiguous, fail rather than wait if the free ports are too
+// fragmented.
+func TakeServices(specs []ServiceSpec) (map[string][]int, error) {
+	need := 0
+	seen := make(map[string]struct{}, len(specs))
+	for _, spec := range specs {
+		if spec.Count <= 0 {
+			return nil, fmt.Errorf("freeport: cannot take ports for service %q: %w", spec.Name, invalidCountError(spec.Count))
+		}
+		if _, ok := seen[spec.Name]; ok {
+			return nil, fmt.Errorf("freeport: duplicate service %q", spec.Name)
+		}
+		seen[spec.Name] = struct{}{}
+		need += spec.Count
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	if need > total-reserveFree {
+		return nil, tooSmallErrorLocked(need, reserveFree)
+	}
+
+	out := make(map[string][]int, len(specs))
+	undo := func() {
+		for _, ports := range out {
+			for i := len(ports) - 1; i >= 0; i-- {
+				putBackLocked(ports[i])
+			}
+		}
+	}
+	// Take the ports of all services without a consecutive run at once, so
+	// that waiting for them doesn't hold ports taken for an earlier service.
+	var names []string
+	n := 0
+	for _, spec := range specs {
+		if !spec.Consecutive {
+			names = append(names, fmt.Sprintf("%q", spec.Name))
+			n += spec.Count
+		}
+	}
+	if n > 0 {
+		ports, err := takeLocked(n)
+		if err != nil {
+			return nil, fmt.Errorf("freeport: cannot take ports for service %s: %w", strings.Join(names, ", "), err)
+		}
+		for _, spec := range specs {
+			if !spec.Consecutive {
+				out[spec.Name], ports = ports[:spec.Count:spec.Count], ports[spec.Count:]
+			}
+		}
+	}
+	for _, spec := range specs {
+		if !spec.Consecutive {
+			continue
+		}
+		r, err := takeContiguousLocked(spec.Count)
+		if err != nil {
+			undo()
+			return nil, fmt.Errorf("freeport: cannot take ports for service %q: %w", spec.Name, err)
+		}
+		out[spec.Name] = r.Slice()
+	}
+
+	var all []int
+	for _, ports := range out {
+		all = append(all, ports...)
+	}
+	countTake(all)
+	return out, nil
+}
@@ -0,0 +1,68 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeServices(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30008")
+
+	out, err := TakeServices([]ServiceSpec{
+		{Name: "api", Count: 2},
+		{Name: "raft", Count: 3, Consecutive: true},
+	})
+	require.NoError(t, err)
+	require.Len(t, out["api"], 2)
+	require.Len(t, out["raft"], 3)
+	assert.Equal(t, out["raft"][0]+2, out["raft"][2])
+	Return(out["api"])
+	Return(out["raft"])
+	waitForStatsReset(t)
+
+	// Fragment the block so that no three consecutive ports are free.
+	gaps, err := TakeDistinctFrom(2, []int{30001, 30002, 30004, 30005, 30007, 30008})
+	require.NoError(t, err)
+
+	_, err = TakeServices([]ServiceSpec{
+		{Name: "api", Count: 1},
+		{Name: "raft", Count: 3, Consecutive: true},
+	})
+	assert.ErrorContains(t, err, `service "raft"`)
+	assert.ElementsMatch(t, gaps, TakenPorts(), "a failed TakeServices must not keep any ports")
+
+	_, err = TakeServices([]ServiceSpec{{Name: "a", Count: 1}, {Name: "a", Count: 1}})
+	assert.Error(t, err)
+	_, err = TakeServices([]ServiceSpec{{Name: "a", Count: 0}})
+	assert.ErrorIs(t, err, ErrInvalidCount)
+	Return(gaps)
+	waitForStatsReset(t)
+
+	// While waiting for free ports, no consecutive run is held.
+	held, err := Take(7)
+	require.NoError(t, err)
+	done := make(chan map[string][]int)
+	go func() {
+		out, err := TakeServices([]ServiceSpec{
+			{Name: "api", Count: 2},
+			{Name: "raft", Count: 3, Consecutive: true},
+		})
+		assert.NoError(t, err)
+		done <- out
+	}()
+	time.Sleep(100 * time.Millisecond)
+	assert.LessOrEqual(t, len(TakenPorts()), len(held)+1)
+	Return(held)
+	out = <-done
+	require.Len(t, out["api"], 2)
+	require.Len(t, out["raft"], 3)
+	Return(out["api"])
+	Return(out["raft"])
+}
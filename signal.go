@@ -0,0 +1,72 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var signalOnce sync.Once
+
+// raiseSignal delivers sig to the current process again. It is a variable so
+// tests can observe the re-raise without being terminated.
+var raiseSignal = func(sig os.Signal) error {
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
+
+// InstallSignalHandler makes freeport release its port block lock and any
+// listeners held with SetHoldDuringTake when the process receives SIGTERM, so
+// that the next run doesn't find the block still locked while the process is
+// being torn down. It is opt-in because a library shouldn't claim signals
+// unasked, and calling it more than once has no further effect.
+//
+// The signal is not swallowed: handlers registered with signal.Notify receive
+// it as usual, and once the block is released freeport stops listening for
+// SIGTERM and raises it again, so that a process without other handlers
+// terminates the way it would have without freeport. Other handlers may
+// therefore see the signal twice.
+func InstallSignalHandler() {
+	signalOnce.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGTERM)
+		go func() {
+			sig := <-ch
+			logf("INFO", "received %v; releasing the port block", sig)
+
+			mu.Lock()
+			releaseListenersLocked()
+			mu.Unlock()
+
+			signal.Stop(ch)
+			if err := raiseSignal(sig); err != nil {
+				logf("WARN", "unable to re-raise %v: %v", sig, err)
+				os.Exit(128 + int(syscall.SIGTERM))
+			}
+		}()
+	})
+}
+
+// releaseListenersLocked closes the block locks and all holds. It must be
+// called with mu held.
+func releaseListenersLocked() {
+	if lockLn != nil {
+		lockLn.Close()
+		lockLn = nil
+	}
+	for _, ln := range extraLocks {
+		ln.Close()
+	}
+	extraLocks = nil
+	for port, ln := range holds {
+		ln.Close()
+		delete(holds, port)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstallSignalHandler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGTERM cannot be sent to the current process on Windows")
+	}
+	defer reset()
+
+	raised := make(chan os.Signal, 1)
+	defer func(orig func(os.Signal) error) { raiseSignal = orig }(raiseSignal)
+	raiseSignal = func(sig os.Signal) error {
+		raised <- sig
+		return nil
+	}
+
+	ports, err := Take(1)
+	require.NoError(t, err)
+	defer Return(ports)
+
+	InstallSignalHandler()
+	InstallSignalHandler()
+
+	self, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, self.Signal(syscall.SIGTERM))
+	select {
+	case sig := <-raised:
+		assert.Equal(t, syscall.SIGTERM, sig)
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal was not re-raised")
+	}
+
+	mu.Lock()
+	assert.Nil(t, lockLn)
+	mu.Unlock()
+}
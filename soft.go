@@ -0,0 +1,135 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"container/list"
+	"fmt"
+	"time"
+)
+
+// softRevokeGrace is how long the holder of a revoked soft reservation has to
+// release it before its ports are reclaimed forcibly.
+var softRevokeGrace = 5 * time.Second
+
+// SoftReservation is a set of ports that freeport may ask to have back when
+// the pool runs dry, for opportunistic users that can make do without them.
+type SoftReservation struct {
+	ports   []int
+	revoked chan struct{}
+
+	// elem, revokedAt and released are guarded by mu.
+	elem      *list.Element
+	revokedAt time.Time
+	released  bool
+}
+
+// TakeSoft takes n free ports as a soft reservation. When a regular Take would
+// otherwise have to wait for ports, the oldest soft reservations are revoked:
+// their Revoked channel is closed and the holder is expected to stop using the
+// ports and call Release. Ports that are not released within 5 seconds of the
+// revocation are reclaimed forcibly. Unlike Take, TakeSoft does not wait for
+// ports to be returned; it fails if not enough ports are free.
+func TakeSoft(n int) (*SoftReservation, error) {
+	if n <= 0 {
+		return nil, invalidCountError(n)
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	if freePorts.Len()-reserveFree < n {
+		return nil, fmt.Errorf("freeport: not enough free ports for a soft reservation of %d ports", n)
+	}
+	ports, err := takeLocked(n)
+	if err != nil {
+		return nil, err
+	}
+	countTake(ports)
+
+	r := &SoftReservation{ports: ports, revoked: make(chan struct{})}
+	r.elem = softReservations.PushBack(r)
+	for _, port := range ports {
+		softHeld[port] = r
+	}
+	return r, nil
+}
+
+// Ports returns the reserved ports.
+func (r *SoftReservation) Ports() []int {
+	return append([]int(nil), r.ports...)
+}
+
+// Revoked returns a channel that is closed once freeport wants the ports back.
+func (r *SoftReservation) Revoked() <-chan struct{} {
+	return r.revoked
+}
+
+// Release returns the reserved ports to the pool. Ports that were already
+// returned some other way are skipped, even if someone else has taken them
+// since. It is safe to call more than once and after the reservation has been
+// reclaimed.
+func (r *SoftReservation) Release() {
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	if r.released {
+		return
+	}
+	r.released = true
+	softReservations.Remove(r.elem)
+	returnLocked(r.heldLocked(), ReasonUnspecified)
+}
+
+// heldLocked returns the reserved ports that have not been returned yet. It
+// must be called with mu held.
+func (r *SoftReservation) heldLocked() []int {
+	var held []int
+	for _, port := range r.ports {
+		if softHeld[port] == r {
+			held = append(held, port)
+		}
+	}
+	return held
+}
+
+// revokeSoftLocked revokes the oldest soft reservations that have not been
+// revoked yet until at least need ports have been asked for, counting the
+// ports of reservations revoked earlier. It must be called with mu held.
+func revokeSoftLocked(need int) {
+	for elem := softReservations.Front(); elem != nil && need > 0; elem = elem.Next() {
+		r := elem.Value.(*SoftReservation)
+		if r.revokedAt.IsZero() {
+			logf("INFO", "pool exhausted; revoking soft reservation of ports %v", logPorts(r.ports))
+			r.revokedAt = time.Now()
+			close(r.revoked)
+		}
+		need -= len(r.heldLocked())
+	}
+}
+
+// reclaimSoft returns the ports of soft reservations whose holders did not
+// release them in time after they were revoked.
+func reclaimSoft() {
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	for elem := softReservations.Front(); elem != nil; {
+		next := elem.Next()
+		r := elem.Value.(*SoftReservation)
+		if !r.revokedAt.IsZero() && now.Sub(r.revokedAt) >= softRevokeGrace {
+			logf("WARN", "soft reservation of ports %v not released %v after revocation; reclaiming", logPorts(r.ports), softRevokeGrace)
+			r.released = true
+			softReservations.Remove(elem)
+			returnLocked(r.heldLocked(), ReasonSoftRevoked)
+		}
+		elem = next
+	}
+}
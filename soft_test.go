@@ -0,0 +1,104 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeSoft(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	soft, err := TakeSoft(4)
+	require.NoError(t, err)
+	_, err = TakeSoft(4)
+	require.Error(t, err, "soft reservations must not wait for ports")
+
+	hard, err := Take(3)
+	require.NoError(t, err)
+	select {
+	case <-soft.Revoked():
+		t.Fatal("revoked while the pool still had free ports")
+	default:
+	}
+
+	// The pool is dry now, so the next take revokes the soft reservation and
+	// gets its ports once they are released.
+	done := make(chan []int)
+	go func() {
+		ports, err := Take(2)
+		assert.NoError(t, err)
+		done <- ports
+	}()
+
+	select {
+	case <-soft.Revoked():
+	case <-time.After(5 * time.Second):
+		t.Fatal("soft reservation was not revoked")
+	}
+	soft.Release()
+	soft.Release()
+
+	select {
+	case ports := <-done:
+		Return(ports)
+	case <-time.After(5 * time.Second):
+		t.Fatal("take did not get the released ports")
+	}
+	Return(hard)
+}
+
+func TestTakeSoftForcedReclaim(t *testing.T) {
+	defer reset()
+	defer func(grace time.Duration) { softRevokeGrace = grace }(softRevokeGrace)
+	softRevokeGrace = 100 * time.Millisecond
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30003")
+
+	soft, err := TakeSoft(3)
+	require.NoError(t, err)
+
+	// The holder never releases its ports.
+	ports, err := Take(1)
+	require.NoError(t, err)
+	assert.Subset(t, soft.Ports(), ports)
+	<-soft.Revoked()
+
+	assert.Equal(t, uint64(3), ReturnReasons()[ReasonSoftRevoked])
+	soft.Release()
+	Return(ports)
+}
+
+func TestSoftReclaimAfterReturn(t *testing.T) {
+	defer reset()
+	defer func(grace time.Duration) { softRevokeGrace = grace }(softRevokeGrace)
+	softRevokeGrace = 50 * time.Millisecond
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30002")
+
+	r, err := TakeSoft(1)
+	require.NoError(t, err)
+	Return(r.Ports())
+	assert.Eventually(t, func() bool {
+		_, numPending, _ := stats()
+		return numPending == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Someone else takes the port the reservation gave up.
+	ports, err := Take(2)
+	require.NoError(t, err)
+	require.Contains(t, ports, r.Ports()[0])
+
+	mu.Lock()
+	revokeSoftLocked(1)
+	mu.Unlock()
+	time.Sleep(2 * softRevokeGrace)
+	reclaimSoft()
+	r.Release()
+	assert.ElementsMatch(t, ports, TakenPorts(), "the reservation must not return ports it no longer holds")
+	Return(ports)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// sharedState is the serialized form of a share of the pool handed from a
+// parent process to a child.
+type sharedState struct {
+	Base  int   `json:"base"`
+	Size  int   `json:"size"`
+	Ports []int `json:"ports"`
+}
+
+// ExportState hands half of the currently free ports over to a child process,
+// e.g. one started by a test that also imports freeport. The returned data is
+// meant to be passed to ImportState in the child, e.g. via an environment
+// variable or a file. The exported ports are removed from this process's pool
+// for good, so the parent and the child never hand out the same port.
+func ExportState() []byte {
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	// Only ports of the first block are shared; the child cannot check
+	// ports of blocks reserved with SetBlockCount against the block it is
+	// given.
+	state := sharedState{Base: firstPort, Size: blockSize}
+	n := freePorts.Len() / 2
+	for elem := freePorts.Back(); elem != nil && n > 0; {
+		prev := elem.Prev()
+		if port := elem.Value.(int); port > firstPort && port < firstPort+blockSize {
+			freePorts.Remove(elem)
+			delete(unverified, port)
+			state.Ports = append(state.Ports, port)
+			n--
+		}
+		elem = prev
+	}
+	total -= len(state.Ports)
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		panic("freeport: cannot marshal state: " + err.Error())
+	}
+	return data
+}
+
+// ImportState makes this process use the share of a parent's pool exported by
+// ExportState instead of reserving a port block of its own. It must be called
+// before any ports are taken.
+func ImportState(data []byte) error {
+	var state sharedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("freeport: invalid state: %w", err)
+	}
+	for _, port := range state.Ports {
+		if port <= state.Base || port >= state.Base+state.Size {
+			return fmt.Errorf("freeport: invalid state: port %d outside of block [%d, %d]", port, state.Base, state.Base+state.Size-1)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	imported := false
+	once.Do(func() {
+		imported = true
+		firstPort, blockSize = state.Base, state.Size
+		initPool()
+		for _, port := range state.Ports {
+			if used := isPortInUse(port); !used {
+				freePorts.PushBack(port)
+			}
+		}
+		total = freePorts.Len()
+		logf("INFO", "imported %d of %d ports from parent block [%d, %d]", total, len(state.Ports), firstPort, firstPort+blockSize-1)
+		startBackground()
+	})
+	if !imported {
+		return fmt.Errorf("freeport: cannot import state after initialization")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImportState(t *testing.T) {
+	defer reset()
+
+	parentTotal := func() int {
+		ports, err := Take(1)
+		require.NoError(t, err)
+		Return(ports)
+		return waitForStatsReset(t)
+	}()
+
+	data := ExportState()
+	parentFree := peekAllFree()
+	assert.Equal(t, parentTotal-parentTotal/2, len(parentFree))
+	require.Error(t, ImportState(data), "importing into an initialized pool must fail")
+
+	// Pretend to be the child.
+	base, size := firstPort, blockSize
+	reset()
+	require.NoError(t, ImportState(data))
+	assert.Equal(t, base, firstPort)
+	assert.Equal(t, size, blockSize)
+
+	childFree := peekAllFree()
+	assert.Len(t, childFree, parentTotal/2)
+	for _, port := range childFree {
+		assert.NotContains(t, parentFree, port)
+	}
+
+	ports, err := Take(len(childFree))
+	require.NoError(t, err)
+	Return(ports)
+
+	require.Error(t, ImportState([]byte(`{"base":20000,"size":10,"ports":[20010]}`)))
+	require.Error(t, ImportState([]byte(`not json`)))
+}
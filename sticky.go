@@ -0,0 +1,94 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// TakeSticky is like Take but prefers the ports that were last handed out for
+// key, so that the same test gets the same ports across runs when possible.
+// The ports handed out for key are recorded in a file under os.TempDir. A
+// remembered port that is no longer free is replaced by a fresh one and the
+// record is updated.
+func TakeSticky(key string, n int) ([]int, error) {
+	if n <= 0 {
+		return nil, invalidCountError(n)
+	}
+
+	remembered := readSticky(key)
+
+	defer runHooks()
+	mu.Lock()
+	once.Do(initialize)
+
+	ports := make([]int, 0, n)
+	for _, port := range remembered {
+		if len(ports) == n {
+			break
+		}
+		if takePortLocked(port) {
+			ports = append(ports, port)
+		}
+	}
+
+	if len(ports) < n {
+		fresh, err := takeLocked(n - len(ports))
+		if err != nil {
+			for _, port := range ports {
+				putBackLocked(port)
+			}
+			mu.Unlock()
+			return nil, err
+		}
+		ports = append(ports, fresh...)
+	}
+	sortResultsLocked(ports)
+	countTake(ports)
+	mu.Unlock()
+
+	if err := writeSticky(key, ports); err != nil {
+		logf("WARN", "failed to record sticky ports for %q: %v", key, err)
+	}
+	return ports, nil
+}
+
+// stickyPath returns the file used to remember the ports handed out for key.
+func stickyPath(key string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, key)
+	return filepath.Join(os.TempDir(), "freeport-sticky-"+safe)
+}
+
+func readSticky(key string) []int {
+	out, err := os.ReadFile(stickyPath(key))
+	if err != nil {
+		return nil
+	}
+
+	var ports []int
+	for _, field := range strings.Split(strings.TrimSpace(string(out)), ",") {
+		if port, err := strconv.Atoi(field); err == nil {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+func writeSticky(key string, ports []int) error {
+	fields := make([]string, len(ports))
+	for i, port := range ports {
+		fields[i] = strconv.Itoa(port)
+	}
+
+	return writeFileAtomic(stickyPath(key), []byte(strings.Join(fields, ",")+"\n"), 0o644)
+}
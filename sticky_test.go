@@ -0,0 +1,41 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeSticky(t *testing.T) {
+	defer reset()
+	t.Setenv("TMPDIR", t.TempDir())
+
+	first, err := TakeSticky("TestTakeSticky/sub", 3)
+	require.NoError(t, err)
+	Return(first)
+	waitForStatsReset(t)
+
+	// The same key gets the same ports back once they are free again.
+	second, err := TakeSticky("TestTakeSticky/sub", 3)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	Return(second)
+	waitForStatsReset(t)
+
+	// A remembered port that is now busy is replaced and the record updated.
+	ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", first[0]))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	third, err := TakeSticky("TestTakeSticky/sub", 3)
+	require.NoError(t, err)
+	defer Return(third)
+	assert.NotContains(t, third, first[0])
+	assert.Equal(t, first[1:], third[:2])
+	assert.Equal(t, third, readSticky("TestTakeSticky/sub"))
+}
@@ -0,0 +1,60 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// tlsVerifyConfig is the TLS configuration candidate ports are checked with,
+// or nil to skip the check. It is read without holding mu.
+var tlsVerifyConfig atomic.Pointer[tls.Config]
+
+// tlsAcceptTimeout bounds how long verifyTLS waits for its own connection to
+// be accepted.
+const tlsAcceptTimeout = time.Second
+
+// SetTLSVerify makes verification of a candidate port also set up a TLS
+// listener with cfg on it and confirm that the listener accepts a connection.
+// No handshake is performed; this catches environments, such as some SELinux
+// policies, that allow the bind but not a usable listener. The check runs when
+// a port is handed out, without freeport's internal lock held, and is skipped
+// when the pool is filled or returned ports are re-verified. cfg must carry a
+// certificate source, the same as for tls.Listen. Passing nil disables the
+// check.
+func SetTLSVerify(cfg *tls.Config) error {
+	if cfg != nil && len(cfg.Certificates) == 0 && cfg.GetCertificate == nil && cfg.GetConfigForClient == nil {
+		return errors.New("freeport: TLS config has neither Certificates, GetCertificate, nor GetConfigForClient set")
+	}
+	tlsVerifyConfig.Store(cfg)
+	return nil
+}
+
+// verifyTLS wraps ln in a TLS listener using cfg and checks that a connection
+// to it is accepted. ln is left open.
+func verifyTLS(ln net.Listener, cfg *tls.Config) error {
+	if dl, ok := ln.(interface{ SetDeadline(time.Time) error }); ok {
+		if err := dl.SetDeadline(time.Now().Add(tlsAcceptTimeout)); err != nil {
+			return err
+		}
+		defer dl.SetDeadline(time.Time{})
+	}
+
+	conn, err := net.DialTimeout("tcp", ln.Addr().String(), tlsAcceptTimeout)
+	if err != nil {
+		return fmt.Errorf("freeport: TLS verification of %s failed: %w", ln.Addr(), err)
+	}
+	defer conn.Close()
+
+	accepted, err := tls.NewListener(ln, cfg).Accept()
+	if err != nil {
+		return fmt.Errorf("freeport: TLS verification of %s failed: %w", ln.Addr(), err)
+	}
+	return accepted.Close()
+}
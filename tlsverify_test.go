@@ -0,0 +1,56 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func selfSignedConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "freeport"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestSetTLSVerify(t *testing.T) {
+	defer reset()
+	defer SetTLSVerify(nil)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	assert.Error(t, SetTLSVerify(&tls.Config{}))
+	assert.Nil(t, tlsVerifyConfig.Load())
+
+	require.NoError(t, SetTLSVerify(selfSignedConfig(t)))
+
+	ports, err := Take(3)
+	require.NoError(t, err)
+	assert.Len(t, ports, 3)
+	for _, port := range ports {
+		assert.NoError(t, Probe(port))
+	}
+	Return(ports)
+
+	require.NoError(t, SetTLSVerify(nil))
+	assert.Nil(t, tlsVerifyConfig.Load())
+}
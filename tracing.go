@@ -0,0 +1,49 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "sync/atomic"
+
+// Tracer creates spans for freeport operations. It is a minimal interface so
+// that freeport does not depend on a tracing library; adapting it to e.g.
+// OpenTelemetry takes a few lines.
+type Tracer interface {
+	StartSpan(name string) Span
+}
+
+// Span is a single traced operation started by a Tracer.
+//
+// Take spans carry the number of ports requested ("freeport.requested") and
+// the time spent blocked waiting for ports to be returned, in nanoseconds
+// ("freeport.wait_ns"). Return spans carry the number of ports returned
+// ("freeport.returned").
+type Span interface {
+	SetAttribute(key string, value int64)
+	End()
+}
+
+// tracer is the Tracer set by SetTracer, if any.
+var tracer atomic.Pointer[Tracer]
+
+// SetTracer makes Take and Return emit spans via t. Passing nil disables
+// tracing.
+func SetTracer(t Tracer) {
+	if t == nil {
+		tracer.Store(nil)
+		return
+	}
+	tracer.Store(&t)
+}
+
+func startSpan(name string) Span {
+	if t := tracer.Load(); t != nil {
+		return (*t).StartSpan(name)
+	}
+	return noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, int64) {}
+func (noopSpan) End()                       {}
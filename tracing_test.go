@@ -0,0 +1,76 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedSpan struct {
+	tracer *recordingTracer
+	name   string
+	attrs  map[string]int64
+}
+
+func (s *recordedSpan) SetAttribute(key string, value int64) { s.attrs[key] = value }
+func (s *recordedSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.ended = append(s.tracer.ended, s)
+}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	ended []*recordedSpan
+}
+
+func (r *recordingTracer) StartSpan(name string) Span {
+	return &recordedSpan{tracer: r, name: name, attrs: map[string]int64{}}
+}
+
+func TestTracer(t *testing.T) {
+	defer reset()
+	defer SetTracer(nil)
+
+	tr := &recordingTracer{}
+	SetTracer(tr)
+
+	ports, err := Take(3)
+	require.NoError(t, err)
+	Return(ports)
+
+	require.Len(t, tr.ended, 2)
+	assert.Equal(t, "freeport.Take", tr.ended[0].name)
+	assert.Equal(t, int64(3), tr.ended[0].attrs["freeport.requested"])
+	assert.Equal(t, int64(0), tr.ended[0].attrs["freeport.wait_ns"])
+	assert.Equal(t, "freeport.Return", tr.ended[1].name)
+	assert.Equal(t, int64(3), tr.ended[1].attrs["freeport.returned"])
+
+	// Time spent blocked waiting for a Return is recorded.
+	numTotal := waitForStatsReset(t)
+	held, err := Take(numTotal)
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		Return(held)
+	}()
+	ports, err = Take(1)
+	require.NoError(t, err)
+	Return(ports)
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	var waited int64
+	for _, s := range tr.ended {
+		if s.name == "freeport.Take" {
+			waited += s.attrs["freeport.wait_ns"]
+		}
+	}
+	assert.GreaterOrEqual(t, waited, int64(100*time.Millisecond))
+}
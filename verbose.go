@@ -0,0 +1,36 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+// RejectedCandidate is a port that was considered during a take but skipped
+// because it failed verification.
+type RejectedCandidate struct {
+	Port int
+	Err  error
+}
+
+// TakeVerbose is like Take but also reports the candidates that were skipped
+// during this call because they failed verification, together with the reason,
+// to help debug slow or failing allocations. In the common case the rejected
+// slice is empty. Rejected ports are removed from circulation like any port
+// found to be in use.
+func TakeVerbose(n int) (ports []int, rejected []RejectedCandidate, err error) {
+	if n <= 0 {
+		return nil, nil, invalidCountError(n)
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	ports, _, err = takeProgressLocked(n, reserveFree, nil, func(port int, err error) {
+		rejected = append(rejected, RejectedCandidate{Port: port, Err: err})
+	})
+	if err == nil {
+		countTake(ports)
+	}
+	return ports, rejected, err
+}
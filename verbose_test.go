@@ -0,0 +1,37 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeVerbose(t *testing.T) {
+	defer reset()
+
+	ports, rejected, err := TakeVerbose(2)
+	require.NoError(t, err)
+	assert.Empty(t, rejected)
+	Return(ports)
+
+	// Occupy the next candidate so it gets rejected.
+	mu.Lock()
+	next := freePorts.Front().Value.(int)
+	mu.Unlock()
+	ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", next))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	ports, rejected, err = TakeVerbose(1)
+	require.NoError(t, err)
+	defer Return(ports)
+	assert.NotEqual(t, next, ports[0])
+	require.Len(t, rejected, 1)
+	assert.Equal(t, next, rejected[0].Port)
+	assert.Error(t, rejected[0].Err)
+}
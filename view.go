@@ -0,0 +1,78 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "sort"
+
+// PoolView is an immutable snapshot of the pool, taken with View. All of its
+// values come from the same instant and do not change as the pool changes
+// afterwards.
+type PoolView struct {
+	blocks  []PortRange
+	free    []int
+	taken   []int
+	pending []int
+}
+
+// View returns a snapshot of the pool for assertions in tests. Unlike the
+// individual accessors, all values of a view are captured under a single lock,
+// so invariants between them can be checked reliably. View does not initialize
+// the package; before initialization it returns an empty view.
+func View() PoolView {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if firstPort == 0 {
+		return PoolView{}
+	}
+	blocks := make([]PortRange, 0, 1+len(extraBlocks))
+	for _, base := range append([]int{firstPort}, extraBlocks...) {
+		blocks = append(blocks, PortRange{Base: base, Count: blockSize})
+	}
+	return PoolView{
+		blocks:  blocks,
+		free:    listPorts(freePorts),
+		taken:   setPorts(taken),
+		pending: listPorts(pendingPorts),
+	}
+}
+
+// Range returns the first port block, including the port used as its lock.
+// Blocks reserved in addition because of SetBlockCount are not included; use
+// Ranges to get all of them.
+func (v PoolView) Range() PortRange {
+	if len(v.blocks) == 0 {
+		return PortRange{}
+	}
+	return v.blocks[0]
+}
+
+// Ranges returns all reserved port blocks, including the ports used as their
+// locks, starting with the one Range returns.
+func (v PoolView) Ranges() []PortRange {
+	return append([]PortRange(nil), v.blocks...)
+}
+
+// Free returns the ports that could be taken, in ascending order.
+func (v PoolView) Free() []int {
+	return sortedCopy(v.free)
+}
+
+// Taken returns the ports that have been taken and not returned, in ascending
+// order.
+func (v PoolView) Taken() []int {
+	return sortedCopy(v.taken)
+}
+
+// Pending returns the ports that have been returned but not verified to be
+// free yet, in ascending order.
+func (v PoolView) Pending() []int {
+	return sortedCopy(v.pending)
+}
+
+func sortedCopy(ports []int) []int {
+	out := append([]int(nil), ports...)
+	sort.Ints(out)
+	return out
+}
@@ -0,0 +1,38 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestView(t *testing.T) {
+	defer reset()
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30007")
+
+	assert.Equal(t, PoolView{}, View())
+
+	PauseVerification()
+	defer ResumeVerification()
+
+	ports, err := Take(3)
+	require.NoError(t, err)
+	Return(ports[:1])
+
+	v := View()
+	assert.Equal(t, PortRange{Base: 30000, Count: 8}, v.Range())
+	assert.Equal(t, []PortRange{{Base: 30000, Count: 8}}, v.Ranges())
+	assert.Equal(t, []int{30002, 30003}, v.Taken())
+	assert.Equal(t, []int{30001}, v.Pending())
+	assert.Equal(t, []int{30004, 30005, 30006, 30007}, v.Free())
+
+	// The view does not follow the pool, and callers cannot change it.
+	Return(ports[1:])
+	assert.Equal(t, []int{30002, 30003}, v.Taken())
+	v.Free()[0] = 1
+	assert.Equal(t, []int{30004, 30005, 30006, 30007}, v.Free())
+}
@@ -0,0 +1,91 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import "sync"
+
+// pendingTake is a caller blocked in Take. Every waiter has its own condition
+// variable so that freeing a few ports wakes only the waiters that can use
+// them instead of every blocked caller.
+type pendingTake struct {
+	// need is the number of ports the waiter still needs.
+	need int
+	// reserve is the number of free ports the waiter leaves untouched.
+	reserve int
+	// watching is set if the waiter reports its queue position and must be
+	// woken whenever the queue changes.
+	watching bool
+
+	cond *sync.Cond
+}
+
+func newPendingTake(n, reserve int, watching bool) *pendingTake {
+	return &pendingTake{need: n, reserve: reserve, watching: watching, cond: sync.NewCond(&mu)}
+}
+
+// wait blocks until the waiter is woken. It must be called with mu held.
+func (w *pendingTake) wait(need int) {
+	w.need = need
+	w.cond.Wait()
+}
+
+func (w *pendingTake) signal() {
+	w.cond.Signal()
+}
+
+// wakeSatisfiableLocked wakes, in queue order, only as many waiters as the
+// free ports can serve. Waiters that were woken earlier but have not run yet
+// are still queued with their full need, so they are accounted for again and a
+// waiter that can be served is never left asleep. It must be called with mu
+// held.
+func wakeSatisfiableLocked() {
+	if waiters == nil {
+		return
+	}
+
+	remaining := freePorts.Len()
+	for elem := waiters.Front(); elem != nil && remaining > 0; elem = elem.Next() {
+		w := elem.Value.(*pendingTake)
+		usable := remaining - w.reserve
+		if usable <= 0 {
+			continue
+		}
+		w.signal()
+		if usable > w.need {
+			usable = w.need
+		}
+		remaining -= usable
+
+		// Only the head of the queue may take ports in FIFO mode; the next
+		// waiter is woken once the head is done.
+		if fifoTakes {
+			return
+		}
+	}
+}
+
+// wakeDequeuedLocked is called after a waiter left the queue. Waiters that
+// report their queue position are woken since their position changed, and
+// the ports that the departed waiter did not use are offered to the rest. It
+// must be called with mu held.
+func wakeDequeuedLocked() {
+	for elem := waiters.Front(); elem != nil; elem = elem.Next() {
+		if w := elem.Value.(*pendingTake); w.watching {
+			w.signal()
+		}
+	}
+	wakeSatisfiableLocked()
+}
+
+// wakeAllLocked wakes every waiter so that it re-checks its situation, e.g.
+// after a setting that affects all of them changed. It must be called with mu
+// held.
+func wakeAllLocked() {
+	if waiters == nil {
+		return
+	}
+	for elem := waiters.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*pendingTake).signal()
+	}
+}
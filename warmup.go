@@ -0,0 +1,53 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"context"
+	"sort"
+)
+
+// WarmUpContext verifies the free ports that were added to the pool without
+// being checked because of SetWarmProbeCount, initializing the package first if
+// necessary. Ports found to be in use are removed from circulation the same
+// way Take handles stolen ports. If progress is not nil it is called after
+// each port with the number of ports verified so far and the number that
+// needed verification; it is called without any internal locks held.
+//
+// If ctx is cancelled WarmUpContext stops and returns ctx.Err(). The ports
+// verified so far stay verified and the rest are checked lazily by Take as
+// usual.
+func WarmUpContext(ctx context.Context, progress func(done, total int)) error {
+	mu.Lock()
+	once.Do(initialize)
+	pending := make([]int, 0, len(unverified))
+	for port := range unverified {
+		pending = append(pending, port)
+	}
+	mu.Unlock()
+	sort.Ints(pending)
+
+	for i, port := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		mu.Lock()
+		// The port may have been taken, and thereby verified, in the meantime.
+		// A port that left the free list some other way is no longer ours to
+		// check.
+		if _, ok := unverified[port]; ok {
+			delete(unverified, port)
+			if isPortInUse(port) && removePort(freePorts, port) {
+				stolenLocked(port)
+			}
+		}
+		mu.Unlock()
+
+		if progress != nil {
+			progress(i+1, len(pending))
+		}
+	}
+	return nil
+}
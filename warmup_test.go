@@ -0,0 +1,82 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmUpContext(t *testing.T) {
+	defer reset()
+	defer SetWarmProbeCount(-1)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30015")
+
+	ln, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", 30009))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	t.Run("cancelled", func(t *testing.T) {
+		reset()
+		SetWarmProbeCount(5)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		err := WarmUpContext(ctx, func(done, total int) {
+			assert.Equal(t, 10, total)
+			if done == 3 {
+				cancel()
+			}
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		mu.Lock()
+		assert.Len(t, unverified, 7)
+		mu.Unlock()
+		numTotal, numPending, numFree := stats()
+		assert.Equal(t, 15, numTotal)
+		assert.Equal(t, numTotal, numFree+numPending)
+	})
+
+	t.Run("complete", func(t *testing.T) {
+		reset()
+		SetWarmProbeCount(5)
+
+		var last int
+		require.NoError(t, WarmUpContext(context.Background(), func(done, total int) { last = done }))
+		assert.Equal(t, 10, last)
+
+		mu.Lock()
+		assert.Empty(t, unverified)
+		mu.Unlock()
+		numTotal, numPending, numFree := stats()
+		assert.Equal(t, 14, numTotal)
+		assert.Equal(t, numTotal, numFree+numPending)
+		assert.NotContains(t, peekAllFree(), 30009)
+	})
+
+	t.Run("exported", func(t *testing.T) {
+		reset()
+		SetWarmProbeCount(0)
+
+		var state sharedState
+		require.NoError(t, json.Unmarshal(ExportState(), &state))
+		require.NotEmpty(t, state.Ports)
+		child, err := net.ListenTCP("tcp", tcpAddr("127.0.0.1", state.Ports[0]))
+		require.NoError(t, err)
+		defer child.Close()
+
+		numTotal, _, _ := stats()
+		require.NoError(t, WarmUpContext(context.Background(), nil))
+		_, _, _, _, numThefts := Counters()
+		assert.Zero(t, numThefts, "a port bound by the child it was exported to is not stolen")
+		after, numPending, numFree := stats()
+		assert.Equal(t, numTotal, after)
+		assert.Equal(t, after, numFree+numPending)
+	})
+}
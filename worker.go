@@ -0,0 +1,84 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TakeForWorker partitions the port block into workers bands of equal size and
+// takes n free ports from the band of worker, numbered from 0, so that each
+// worker of a sharded test run uses its own, easily recognizable, range of
+// ports. It fails if the band does not have n free ports, unless
+// SetWorkerBorrowing is enabled, in which case the missing ports are taken
+// from anywhere in the block like Take does. Unlike Take it does not wait for
+// ports of the band to be returned.
+func TakeForWorker(worker, workers, n int) ([]int, error) {
+	if n <= 0 {
+		return nil, invalidCountError(n)
+	}
+	if workers <= 0 || worker < 0 || worker >= workers {
+		return nil, fmt.Errorf("freeport: invalid worker %d of %d", worker, workers)
+	}
+
+	defer runHooks()
+	mu.Lock()
+	defer mu.Unlock()
+
+	once.Do(initialize)
+
+	lo, hi := workerBandLocked(worker, workers)
+	var candidates []int
+	for elem := freePorts.Front(); elem != nil; elem = elem.Next() {
+		if port := elem.Value.(int); port >= lo && port <= hi {
+			candidates = append(candidates, port)
+		}
+	}
+	sort.Ints(candidates)
+
+	ports := make([]int, 0, n)
+	for _, port := range candidates {
+		if len(ports) == n || freePorts.Len() <= reserveFree {
+			break
+		}
+		if takePortLocked(port) {
+			ports = append(ports, port)
+		}
+	}
+
+	if len(ports) < n {
+		if !workerBorrowing {
+			for i := len(ports) - 1; i >= 0; i-- {
+				putBackLocked(ports[i])
+			}
+			return nil, fmt.Errorf("freeport: band [%d, %d] of worker %d has only %d of %d free ports", lo, hi, worker, len(ports), n)
+		}
+		borrowed, err := takeLocked(n - len(ports))
+		if err != nil {
+			for i := len(ports) - 1; i >= 0; i-- {
+				putBackLocked(ports[i])
+			}
+			return nil, err
+		}
+		ports = append(ports, borrowed...)
+	}
+
+	countTake(ports)
+	return ports, nil
+}
+
+// workerBandLocked returns the first and last port of the band of worker when
+// the block is split into workers bands. The last band also gets the ports
+// left over by the division. It must be called with mu held.
+func workerBandLocked(worker, workers int) (lo, hi int) {
+	first, count := firstPort+1, blockSize-1
+	width := count / workers
+	lo = first + worker*width
+	hi = lo + width - 1
+	if worker == workers-1 {
+		hi = first + count - 1
+	}
+	return lo, hi
+}
@@ -0,0 +1,43 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package freeport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeForWorker(t *testing.T) {
+	defer reset()
+	defer SetWorkerBorrowing(false)
+	t.Setenv("CL_FREEPORT_RANGE", "30000-30010")
+
+	// 10 ports split into 3 bands: [30001, 30003], [30004, 30006] and
+	// [30007, 30010].
+	ports, err := TakeForWorker(1, 3, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{30004, 30005}, ports)
+
+	last, err := TakeForWorker(2, 3, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []int{30007, 30008, 30009, 30010}, last)
+
+	_, err = TakeForWorker(1, 3, 2)
+	require.Error(t, err)
+	assert.Len(t, TakenPorts(), 6, "a failed TakeForWorker must not keep any ports")
+
+	SetWorkerBorrowing(true)
+	borrowed, err := TakeForWorker(1, 3, 2)
+	require.NoError(t, err)
+	assert.Contains(t, borrowed, 30006)
+
+	_, err = TakeForWorker(3, 3, 1)
+	assert.Error(t, err)
+
+	Return(ports)
+	Return(last)
+	Return(borrowed)
+}